@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// purposeConfirm prefixes the one-time token purpose of admin confirmations; the action follows it,
+// so a token confirms only the action it was requested for
+const purposeConfirm = "chirpy-admin-confirm:"
+
+// confirmationTTL is how long a confirmation token can be used
+const confirmationTTL = 5 * time.Minute
+
+// errNotYourConfirmation means a confirmation token was sent by someone it wasn't issued to
+var errNotYourConfirmation = errors.New("confirmation token was issued to another user")
+
+// admin actions that need a confirmation token
+const (
+	actionResetDatabase    = "reset_database"
+	actionResetMetrics     = "reset_metrics"
+	actionReloadModeration = "reload_moderation"
+	actionSetRole          = "set_role"
+)
+
+var confirmableActions = map[string]bool{
+	actionResetDatabase:    true,
+	actionResetMetrics:     true,
+	actionReloadModeration: true,
+	actionSetRole:          true,
+}
+
+// middlewareRequireRole rejects users whose role does not include min.
+// It must run after middlewareRequireAuth. The role is read from the database
+// on every request so a demotion takes effect immediately.
+func (cfg *apiConfig) middlewareRequireRole(min database.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := requestLogger(r)
+
+			userID, ok := userIDFromContext(r.Context())
+			if !ok {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+				return
+			}
+
+			user, err := cfg.chirpyDatabase.GetUser(userID)
+			if errors.Is(err, database.ErrNotExist) {
+				logger.Info("Authenticated user no longer exists")
+				respondWithError(w, http.StatusUnauthorized, "User no longer exists")
+				return
+			}
+			if err != nil {
+				logger.Error("Failed to look up user", "err", err)
+				respondWithError(w, http.StatusInternalServerError, "Couldn't look up user")
+				return
+			}
+
+			if !user.Role.AtLeast(min) {
+				logger.Warn("User lacks the role for this route", "role", user.Role, "required", min)
+				respondWithError(w, http.StatusForbidden, "You don't have permission to do that")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// postConfirmationHandler issues a short-lived token that lets the caller perform one kind of admin action
+func (cfg *apiConfig) postConfirmationHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	type parameters struct {
+		Action string `json:"action"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		logger.Info("Error decoding parameters", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if !confirmableActions[params.Action] {
+		logger.Info("Confirmation requested for unknown action", "action", params.Action)
+		respondWithError(w, http.StatusBadRequest, "Unknown action")
+		return
+	}
+
+	user, err := cfg.chirpyDatabase.GetUser(userID)
+	if err != nil {
+		logger.Error("Failed to look up user", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create confirmation token")
+		return
+	}
+
+	timeNow := time.Now().UTC()
+	signed, record, err := cfg.newOneTimeToken(purposeConfirm+params.Action, user, confirmationTTL, timeNow)
+	if err != nil {
+		logger.Error("Failed to sign confirmation token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create confirmation token")
+		return
+	}
+	err = cfg.chirpyDatabase.CreateOneTimeToken(record)
+	if err != nil {
+		logger.Error("Failed to store confirmation token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create confirmation token")
+		return
+	}
+
+	type response struct {
+		ConfirmationToken string    `json:"confirmation_token"`
+		Action            string    `json:"action"`
+		ExpiresAt         time.Time `json:"expires_at"`
+	}
+	respondWithJSON(w, http.StatusCreated, response{ConfirmationToken: signed, Action: params.Action, ExpiresAt: record.ExpiresAt})
+
+}
+
+// middlewareRequireConfirmation rejects requests without an X-Confirmation-Token
+// issued to the authenticated user for action. It must run after middlewareRequireAuth.
+func (cfg *apiConfig) middlewareRequireConfirmation(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := requestLogger(r)
+
+			userID, ok := userIDFromContext(r.Context())
+			if !ok {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+				return
+			}
+
+			tokenString := r.Header.Get("X-Confirmation-Token")
+			if tokenString == "" {
+				logger.Info("Admin action is missing a confirmation token", "action", action)
+				respondWithError(w, http.StatusPreconditionRequired, "Confirm this action with a token from POST /admin/confirmations")
+				return
+			}
+
+			// check whose token it is before using it up, so nobody else can spend it
+			purpose := purposeConfirm + action
+			claims, err := cfg.parseOneTimeToken(purpose, tokenString)
+			if err == nil && claims.Subject != strconv.Itoa(userID) {
+				err = errNotYourConfirmation
+			}
+			if err == nil {
+				_, err = cfg.redeemOneTimeToken(purpose, tokenString, time.Now().UTC())
+			}
+			problem := oneTimeTokenProblem(err)
+			if errors.Is(err, errNotYourConfirmation) {
+				problem = "was issued to someone else"
+			}
+			if problem != "" {
+				logger.Warn("Refused confirmation token", "action", action, "err", err)
+				respondWithError(w, http.StatusForbidden, "This confirmation token "+problem+", request a new one")
+				return
+			}
+			if err != nil {
+				logger.Error("Failed to redeem confirmation token", "action", action, "err", err)
+				respondWithError(w, http.StatusInternalServerError, "Couldn't check confirmation token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// putUserRoleHandler changes another user's role
+func (cfg *apiConfig) putUserRoleHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	adminID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(req, "userID"))
+	if err != nil {
+		logger.Info("Invalid user ID in request", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't get user ID")
+		return
+	}
+
+	if id == adminID {
+		// an admin demoting themselves could leave nobody able to administer chirpy
+		logger.Info("Admin attempted to change their own role")
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role")
+		return
+	}
+
+	type parameters struct {
+		Role database.Role `json:"role"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		logger.Info("Error decoding parameters", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if !params.Role.Valid() {
+		logger.Info("Invalid role", "role", params.Role)
+		respondWithError(w, http.StatusBadRequest, "Role must be user, moderator or admin")
+		return
+	}
+
+	user, err := cfg.chirpyDatabase.SetUserRole(id, params.Role)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("User does not exist", "target_user_id", id)
+		respondWithError(w, http.StatusNotFound, "User does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to set user role", "target_user_id", id, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set user role")
+		return
+	}
+
+	logger.Info("Changed user role", "target_user_id", id, "role", params.Role)
+	respondWithJSON(w, http.StatusOK, userResponse(user))
+
+}
+
+// isAdminEmail reports whether email is configured to be an admin
+func (cfg *apiConfig) isAdminEmail(email string) bool {
+	for _, adminEmail := range cfg.adminEmails {
+		if strings.EqualFold(adminEmail, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// promoteAdmins makes every registered and verified user in cfg.adminEmails an admin.
+// Anyone can sign up with or change to an address, so the rest are promoted by
+// getVerifyEmailHandler once they prove they own it.
+func (cfg *apiConfig) promoteAdmins() error {
+	for _, email := range cfg.adminEmails {
+		user, err := cfg.chirpyDatabase.GetUserByEmail(email)
+		if errors.Is(err, database.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !user.Verified || user.Role == database.RoleAdmin {
+			continue
+		}
+		_, err = cfg.chirpyDatabase.SetUserRole(user.ID, database.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		slog.Info("Promoted configured admin", "user_id", user.ID)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+// okHandler stands in for the admin route behind the middleware under test
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// createUserWithRole creates a user and gives them role
+func createUserWithRole(t *testing.T, cfg *apiConfig, email string, role database.Role) database.User {
+	t.Helper()
+	user, err := cfg.chirpyDatabase.CreateUser(email, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = cfg.chirpyDatabase.SetUserRole(user.ID, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestMiddlewareRequireRole(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			users := map[database.Role]database.User{
+				database.RoleUser:      createUserWithRole(t, cfg, "user@example.com", database.RoleUser),
+				database.RoleModerator: createUserWithRole(t, cfg, "moderator@example.com", database.RoleModerator),
+				database.RoleAdmin:     createUserWithRole(t, cfg, "admin@example.com", database.RoleAdmin),
+			}
+
+			tests := []struct {
+				route  database.Role
+				caller database.Role
+				status int
+			}{
+				{database.RoleModerator, database.RoleUser, http.StatusForbidden},
+				{database.RoleModerator, database.RoleModerator, http.StatusOK},
+				{database.RoleModerator, database.RoleAdmin, http.StatusOK},
+				{database.RoleAdmin, database.RoleUser, http.StatusForbidden},
+				{database.RoleAdmin, database.RoleModerator, http.StatusForbidden},
+				{database.RoleAdmin, database.RoleAdmin, http.StatusOK},
+			}
+			for _, tt := range tests {
+				t.Run(string(tt.caller)+" on "+string(tt.route)+" route", func(t *testing.T) {
+					handler := cfg.middlewareRequireRole(tt.route)(okHandler)
+					w := callHandler(t, handler.ServeHTTP, http.MethodGet, "/admin/", users[tt.caller].ID, nil)
+					if w.Code != tt.status {
+						t.Errorf("status %d, want %d", w.Code, tt.status)
+					}
+				})
+			}
+
+			// the role is read on every request, so a demotion applies at once
+			_, err := cfg.chirpyDatabase.SetUserRole(users[database.RoleAdmin].ID, database.RoleUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := cfg.middlewareRequireRole(database.RoleAdmin)(okHandler)
+			if w := callHandler(t, handler.ServeHTTP, http.MethodGet, "/admin/", users[database.RoleAdmin].ID, nil); w.Code != http.StatusForbidden {
+				t.Errorf("demoted admin: status %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+// requestConfirmation asks for a confirmation token for action as userID
+func requestConfirmation(t *testing.T, cfg *apiConfig, userID int, action string) string {
+	t.Helper()
+	w := callHandler(t, cfg.postConfirmationHandler, http.MethodPost, "/admin/confirmations", userID, map[string]string{"action": action})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("request confirmation: status %d: %s", w.Code, w.Body)
+	}
+	response := struct {
+		ConfirmationToken string `json:"confirmation_token"`
+	}{}
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.ConfirmationToken
+}
+
+// confirmAction sends a request for action as userID with token in X-Confirmation-Token, if it isn't empty
+func confirmAction(t *testing.T, cfg *apiConfig, userID int, action, token string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextKeyUserID, userID))
+	if token != "" {
+		req.Header.Set("X-Confirmation-Token", token)
+	}
+	w := httptest.NewRecorder()
+	cfg.middlewareRequireConfirmation(action)(okHandler).ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestMiddlewareRequireConfirmation(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			admin := createUserWithRole(t, cfg, "admin@example.com", database.RoleAdmin)
+			other := createUserWithRole(t, cfg, "other@example.com", database.RoleAdmin)
+
+			if w := callHandler(t, cfg.postConfirmationHandler, http.MethodPost, "/admin/confirmations", admin.ID, map[string]string{"action": "drop_everything"}); w.Code != http.StatusBadRequest {
+				t.Errorf("confirmation for an unknown action: status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+
+			// a token that expired before it was sent
+			past := time.Now().UTC().Add(-2 * confirmationTTL)
+			expired, record, err := cfg.newOneTimeToken(purposeConfirm+actionSetRole, admin, confirmationTTL, past)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.chirpyDatabase.CreateOneTimeToken(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name   string
+				token  string
+				userID int
+				status int
+			}{
+				{"missing", "", admin.ID, http.StatusPreconditionRequired},
+				{"garbage", "not-a-token", admin.ID, http.StatusForbidden},
+				{"wrong action", requestConfirmation(t, cfg, admin.ID, actionReloadModeration), admin.ID, http.StatusForbidden},
+				{"expired", expired, admin.ID, http.StatusForbidden},
+				{"another admin's", requestConfirmation(t, cfg, other.ID, actionSetRole), admin.ID, http.StatusForbidden},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					if status := confirmAction(t, cfg, tt.userID, actionSetRole, tt.token); status != tt.status {
+						t.Errorf("status %d, want %d", status, tt.status)
+					}
+				})
+			}
+
+			// a token works once
+			token := requestConfirmation(t, cfg, admin.ID, actionSetRole)
+			if status := confirmAction(t, cfg, admin.ID, actionSetRole, token); status != http.StatusOK {
+				t.Fatalf("valid token: status %d, want %d", status, http.StatusOK)
+			}
+			if status := confirmAction(t, cfg, admin.ID, actionSetRole, token); status != http.StatusForbidden {
+				t.Errorf("replayed token: status %d, want %d", status, http.StatusForbidden)
+			}
+
+			// a token sent by someone else isn't used up, so its owner can still use it
+			token = requestConfirmation(t, cfg, other.ID, actionSetRole)
+			if status := confirmAction(t, cfg, admin.ID, actionSetRole, token); status != http.StatusForbidden {
+				t.Errorf("another admin's token: status %d, want %d", status, http.StatusForbidden)
+			}
+			if status := confirmAction(t, cfg, other.ID, actionSetRole, token); status != http.StatusOK {
+				t.Errorf("own token after someone else sent it: status %d, want %d", status, http.StatusOK)
+			}
+		})
+	}
+}
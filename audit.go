@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+// recordAuditEvent stores an audit event for a change made by req.
+// A failure is logged rather than returned, since the change itself has already been made.
+func (cfg *apiConfig) recordAuditEvent(logger *slog.Logger, req *http.Request, userID int, eventType string, at time.Time) {
+	_, err := cfg.chirpyDatabase.CreateAuditEvent(database.AuditEvent{
+		UserID:     userID,
+		Type:       eventType,
+		RemoteAddr: req.RemoteAddr,
+		CreatedAt:  at,
+	})
+	if err != nil {
+		logger.Error("Failed to record audit event", "type", eventType, "user_id", userID, "err", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// issuerAccess is the JWT issuer of access tokens
+const issuerAccess = "chirpy-access"
+
+type contextKey string
+
+const contextKeyUserID contextKey = "userID"
+
+// getBearerToken returns the token from the request's Authorization header
+func getBearerToken(r *http.Request) (string, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, found && token != ""
+}
+
+// middlewareRequireAuth rejects requests without a valid bearer token from issuer.
+// Requests that pass have the authenticated user ID in their context.
+func (cfg *apiConfig) middlewareRequireAuth(issuer string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := requestLogger(r)
+
+			tokenString, found := getBearerToken(r)
+			if !found {
+				logger.Info("Request is missing a bearer token")
+				respondWithError(w, http.StatusUnauthorized, "Missing bearer token")
+				return
+			}
+
+			token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+				return []byte(cfg.jwtSecret), nil
+			}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(issuer))
+
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				logger.Info("Access token has expired")
+				respondWithError(w, http.StatusUnauthorized, "Token has expired")
+				return
+			}
+			if errors.Is(err, jwt.ErrTokenInvalidIssuer) {
+				logger.Warn("Invalid token issuer", "expected", issuer)
+				respondWithError(w, http.StatusUnauthorized, "Invalid token issuer")
+				return
+			}
+			if err != nil {
+				logger.Warn("Failed to parse access token", "err", err)
+				respondWithError(w, http.StatusUnauthorized, "Bad Token")
+				return
+			}
+
+			idString, err := token.Claims.GetSubject()
+			if err != nil {
+				logger.Warn("Could not extract subject from token claims", "err", err)
+				respondWithError(w, http.StatusUnauthorized, "Bad Token")
+				return
+			}
+
+			id, err := strconv.Atoi(idString)
+			if err != nil {
+				logger.Warn("Token subject is not a user ID", "err", err)
+				respondWithError(w, http.StatusUnauthorized, "Bad Token")
+				return
+			}
+
+			setRequestUserID(r.Context(), id)
+			ctx := context.WithValue(r.Context(), contextKeyUserID, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// middlewareOptionalAuth lets requests without a bearer token through anonymously.
+// A request that does send a token must pass middlewareRequireAuth, so a bad token is still an error.
+func (cfg *apiConfig) middlewareOptionalAuth(issuer string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := cfg.middlewareRequireAuth(issuer)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, found := getBearerToken(r); !found {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+}
+
+// userIDFromContext returns the user ID stored by middlewareRequireAuth
+func userIDFromContext(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(contextKeyUserID).(int)
+	return id, ok
+}
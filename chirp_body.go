@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// defaultMaxChirpLength is the longest chirp allowed, in user-perceived characters
+const defaultMaxChirpLength = 140
+
+// chirpBodyError is the response body for a chirp that fails validation.
+// It carries the limit so clients can show an accurate counter.
+type chirpBodyError struct {
+	Message   string `json:"error"`
+	Length    int    `json:"length"`
+	MaxLength int    `json:"max_length"`
+}
+
+// normalizeChirpBody validates a chirp body and returns it in NFC form.
+// Length is counted in grapheme clusters, so an emoji made of several code points counts once.
+func (cfg *apiConfig) normalizeChirpBody(body string) (string, *chirpBodyError) {
+	// encoding/json turns invalid UTF-8 and lone surrogates into U+FFFD while decoding,
+	// so a replacement character is treated the same as the invalid input it stands for
+	if !utf8.ValidString(body) || strings.ContainsRune(body, utf8.RuneError) {
+		return "", &chirpBodyError{Message: "Chirp is not valid UTF-8", MaxLength: cfg.maxChirpLength}
+	}
+
+	body = norm.NFC.String(body)
+	length := uniseg.GraphemeClusterCount(body)
+
+	for _, r := range body {
+		if unicode.IsControl(r) && r != '\n' {
+			return "", &chirpBodyError{
+				Message:   fmt.Sprintf("Chirp contains a control character (%U)", r),
+				Length:    length,
+				MaxLength: cfg.maxChirpLength,
+			}
+		}
+	}
+
+	if strings.TrimSpace(body) == "" {
+		return "", &chirpBodyError{Message: "Chirp is empty", Length: length, MaxLength: cfg.maxChirpLength}
+	}
+
+	bodyErr := cfg.checkChirpLength(body)
+	if bodyErr != nil {
+		return "", bodyErr
+	}
+	return body, nil
+}
+
+// checkChirpLength reports a body that is over the limit.
+// Moderation can mask a short word with a longer mask, so bodies are checked again once moderated.
+func (cfg *apiConfig) checkChirpLength(body string) *chirpBodyError {
+	length := uniseg.GraphemeClusterCount(body)
+	if length > cfg.maxChirpLength {
+		return &chirpBodyError{
+			Message:   fmt.Sprintf("Chirp is too long, the limit is %d characters", cfg.maxChirpLength),
+			Length:    length,
+			MaxLength: cfg.maxChirpLength,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/moderation"
+)
+
+func TestNormalizeChirpBody(t *testing.T) {
+	cfg := &apiConfig{maxChirpLength: 10}
+	tests := []struct {
+		name   string
+		body   string
+		want   string
+		length int // reported with the error; 0 when none is expected or the body isn't counted
+		ok     bool
+	}{
+		{"plain", "hello", "hello", 0, true},
+		{"newlines are allowed", "one\ntwo", "one\ntwo", 0, true},
+		{"composed to NFC", "cafe\u0301", "caf\u00e9", 0, true},
+		{"already NFC", "caf\u00e9", "caf\u00e9", 0, true},
+		{"Hangul jamo compose", "\u1100\u1161", "\uac00", 0, true},
+		{"at the limit", strings.Repeat("a", 10), strings.Repeat("a", 10), 0, true},
+		{"over the limit", strings.Repeat("a", 11), "", 11, false},
+		// each of these is one grapheme cluster made of several code points
+		{"combining marks count once", strings.Repeat("o\u0302\u0323", 10), strings.Repeat("\u1ed9", 10), 0, true},
+		{"ZWJ family emoji counts once", strings.Repeat("\U0001F468\u200d\U0001F469\u200d\U0001F467", 10), strings.Repeat("\U0001F468\u200d\U0001F469\u200d\U0001F467", 10), 0, true},
+		{"flags count once", strings.Repeat("\U0001F1F3\U0001F1FF", 10), strings.Repeat("\U0001F1F3\U0001F1FF", 10), 0, true},
+		{"skin tones count once", strings.Repeat("\U0001F44D\U0001F3FD", 11), "", 11, false},
+		{"invalid UTF-8", "bad \xff byte", "", 0, false},
+		{"truncated sequence", "cut \xe2\x82", "", 0, false},
+		{"replacement character", "decoded \ufffd", "", 0, false},
+		{"control character", "bell\a", "", 5, false},
+		{"empty", "", "", 0, false},
+		{"only spaces", "   ", "", 3, false},
+		{"only whitespace", " \n\u3000", "", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, bodyErr := cfg.normalizeChirpBody(tt.body)
+			if tt.ok {
+				if bodyErr != nil {
+					t.Fatalf("normalizeChirpBody(%q) refused: %+v", tt.body, bodyErr)
+				}
+				if got != tt.want {
+					t.Errorf("normalizeChirpBody(%q) = %q, want %q", tt.body, got, tt.want)
+				}
+				return
+			}
+			if bodyErr == nil {
+				t.Fatalf("normalizeChirpBody(%q) = %q, want it refused", tt.body, got)
+			}
+			if bodyErr.Length != tt.length || bodyErr.MaxLength != cfg.maxChirpLength {
+				t.Errorf("normalizeChirpBody(%q) reported length %d of %d, want %d of %d",
+					tt.body, bodyErr.Length, bodyErr.MaxLength, tt.length, cfg.maxChirpLength)
+			}
+		})
+	}
+}
+
+func TestPostChirpBodyChecks(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			cfg.maxChirpLength = 10
+
+			// a rule that masks a one letter word makes bodies longer
+			rules := filepath.Join(t.TempDir(), "rules.json")
+			err := os.WriteFile(rules, []byte(`{"rules": [{"type": "wordlist", "action": "mask", "words": ["x"]}]}`), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.moderator, err = moderation.NewModerator(rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user, err := cfg.chirpyDatabase.CreateUser("poster@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			original, err := cfg.chirpyDatabase.CreateChirp(database.Chirp{Body: "original", AuthorID: user.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name   string
+				params map[string]any
+				status int
+				length int
+			}{
+				{"masked within the limit", map[string]any{"body": "a x"}, http.StatusCreated, 0},
+				{"masked past the limit", map[string]any{"body": "x x x"}, http.StatusBadRequest, 14},
+				{"empty", map[string]any{"body": ""}, http.StatusBadRequest, 0},
+				{"whitespace", map[string]any{"body": " \n "}, http.StatusBadRequest, 3},
+				{"empty quote", map[string]any{"body": "", "quote_of_id": original.ID}, http.StatusBadRequest, 0},
+				{"whitespace quote", map[string]any{"body": "  ", "quote_of_id": original.ID}, http.StatusBadRequest, 2},
+				{"quote", map[string]any{"body": "so true", "quote_of_id": original.ID}, http.StatusCreated, 0},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					w := callHandler(t, cfg.postChirpHandler, http.MethodPost, "/api/chirps", user.ID, tt.params)
+					if w.Code != tt.status {
+						t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+					}
+					if tt.status != http.StatusBadRequest {
+						return
+					}
+					bodyErr := chirpBodyError{}
+					err := json.NewDecoder(w.Body).Decode(&bodyErr)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if bodyErr.Length != tt.length || bodyErr.MaxLength != cfg.maxChirpLength {
+						t.Errorf("error reported length %d of %d, want %d of %d", bodyErr.Length, bodyErr.MaxLength, tt.length, cfg.maxChirpLength)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/mailer"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting chirpy needs to start.
+// Values come from, in increasing order of precedence: the defaults below,
+// an optional YAML file, environment variables, and command line flags.
+type Config struct {
+	Server struct {
+		Host     string `yaml:"host"`
+		Port     int    `yaml:"port"`
+		FileRoot string `yaml:"file_root"`
+		Debug    bool   `yaml:"debug"`
+		// ShutdownTimeout is how long in-flight requests get to finish after SIGINT or SIGTERM
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		// PublicURL is where users reach the server, used for links in emails
+		PublicURL string `yaml:"public_url"`
+	} `yaml:"server"`
+	Database struct {
+		Driver string `yaml:"driver"`
+		Path   string `yaml:"path"`
+	} `yaml:"database"`
+	Auth struct {
+		JWTSecret       string        `yaml:"jwt_secret"`
+		PolkaKey        string        `yaml:"polka_key"`
+		AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+		RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+		// AdminEmails are promoted to admin once verified, at startup or when they verify
+		AdminEmails []string `yaml:"admin_emails"`
+		// RequireVerifiedEmail stops users posting chirps until they verify their email address
+		RequireVerifiedEmail bool `yaml:"require_verified_email"`
+		// TOTPKey is a base64 AES-256 key that encrypts TOTP secrets in the database
+		TOTPKey string `yaml:"totp_key"`
+		// MetricsToken is the bearer token Prometheus scrapes /metrics with
+		MetricsToken string `yaml:"metrics_token"`
+	} `yaml:"auth"`
+	Chirps struct {
+		MaxLength       int    `yaml:"max_length"`
+		ModerationRules string `yaml:"moderation_rules"`
+	} `yaml:"chirps"`
+	Mail struct {
+		// Driver defaults to file with --debug and to none otherwise
+		Driver       string `yaml:"driver"`
+		From         string `yaml:"from"`
+		Dir          string `yaml:"dir"`
+		SMTPHost     string `yaml:"smtp_host"`
+		SMTPPort     int    `yaml:"smtp_port"`
+		SMTPUsername string `yaml:"smtp_username"`
+		SMTPPassword string `yaml:"smtp_password"`
+	} `yaml:"mail"`
+	Log struct {
+		Format string `yaml:"format"`
+		Level  string `yaml:"level"`
+	} `yaml:"log"`
+}
+
+func defaultConfig() Config {
+	config := Config{}
+	config.Server.Host = "localhost"
+	config.Server.Port = 8080
+	config.Server.FileRoot = "."
+	config.Server.ShutdownTimeout = 30 * time.Second
+	config.Database.Driver = database.DriverJSON
+	config.Auth.AccessTokenTTL = time.Hour
+	config.Auth.RefreshTokenTTL = 60 * 24 * time.Hour
+	config.Chirps.MaxLength = defaultMaxChirpLength
+	config.Mail.From = "chirpy@localhost"
+	config.Mail.SMTPPort = 587
+	config.Log.Format = logFormatText
+	config.Log.Level = "info"
+	return config
+}
+
+// setting ties one Config field to its flag and environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	field func(*Config) any
+}
+
+var settings = []setting{
+	{"host", "CHIRPY_HOST", "Interface to listen on", func(c *Config) any { return &c.Server.Host }},
+	{"port", "CHIRPY_PORT", "Port to listen on", func(c *Config) any { return &c.Server.Port }},
+	{"file-root", "CHIRPY_FILE_ROOT", "Directory served under /app", func(c *Config) any { return &c.Server.FileRoot }},
+	{"shutdown-timeout", "CHIRPY_SHUTDOWN_TIMEOUT", "How long to wait for in-flight requests when shutting down", func(c *Config) any { return &c.Server.ShutdownTimeout }},
+	{"public-url", "CHIRPY_PUBLIC_URL", "Base URL used in links sent to users (defaults to http://<host>:<port>)", func(c *Config) any { return &c.Server.PublicURL }},
+	{"debug", "CHIRPY_DEBUG", "Enable debug mode, which starts with an empty database", func(c *Config) any { return &c.Server.Debug }},
+	{"db-driver", "CHIRPY_DB_DRIVER", "Database backend to use: json or sqlite", func(c *Config) any { return &c.Database.Driver }},
+	{"db-path", "CHIRPY_DB_PATH", "Path to the database file (defaults to ./chirpy_database.<driver>)", func(c *Config) any { return &c.Database.Path }},
+	{"jwt-secret", "JWT_SECRET", "Key used to sign access tokens", func(c *Config) any { return &c.Auth.JWTSecret }},
+	{"polka-key", "POLKA_KEY", "API key Polka uses to call the webhook", func(c *Config) any { return &c.Auth.PolkaKey }},
+	{"require-verified-email", "CHIRPY_REQUIRE_VERIFIED_EMAIL", "Only let users with a verified email address post chirps", func(c *Config) any { return &c.Auth.RequireVerifiedEmail }},
+	{"metrics-token", "CHIRPY_METRICS_TOKEN", "Bearer token needed to scrape /metrics (scraping is refused when empty)", func(c *Config) any { return &c.Auth.MetricsToken }},
+	{"totp-key", "CHIRPY_TOTP_KEY", "Base64 encoded 32 byte key that encrypts TOTP secrets (derived from the JWT secret when empty)", func(c *Config) any { return &c.Auth.TOTPKey }},
+	{"access-token-ttl", "CHIRPY_ACCESS_TOKEN_TTL", "How long access tokens are valid", func(c *Config) any { return &c.Auth.AccessTokenTTL }},
+	{"refresh-token-ttl", "CHIRPY_REFRESH_TOKEN_TTL", "How long refresh tokens are valid", func(c *Config) any { return &c.Auth.RefreshTokenTTL }},
+	{"admin-emails", "CHIRPY_ADMIN_EMAILS", "Comma-separated emails of users who are made admins", func(c *Config) any { return &c.Auth.AdminEmails }},
+	{"max-chirp-length", "CHIRPY_MAX_CHIRP_LENGTH", "Longest chirp allowed, in user-perceived characters", func(c *Config) any { return &c.Chirps.MaxLength }},
+	{"moderation-rules", "CHIRPY_MODERATION_RULES", "Path to a JSON moderation rules file (defaults to the built-in word list)", func(c *Config) any { return &c.Chirps.ModerationRules }},
+	{"mail-driver", "CHIRPY_MAIL_DRIVER", "How email is delivered: log, file, smtp or none (defaults to file with --debug, otherwise none)", func(c *Config) any { return &c.Mail.Driver }},
+	{"mail-from", "CHIRPY_MAIL_FROM", "Sender address of emails", func(c *Config) any { return &c.Mail.From }},
+	{"mail-dir", "CHIRPY_MAIL_DIR", "Directory the file mail driver writes messages to (defaults to mail with --debug)", func(c *Config) any { return &c.Mail.Dir }},
+	{"smtp-host", "CHIRPY_SMTP_HOST", "SMTP server host", func(c *Config) any { return &c.Mail.SMTPHost }},
+	{"smtp-port", "CHIRPY_SMTP_PORT", "SMTP server port", func(c *Config) any { return &c.Mail.SMTPPort }},
+	{"smtp-username", "CHIRPY_SMTP_USERNAME", "SMTP username, leave empty to send without authenticating", func(c *Config) any { return &c.Mail.SMTPUsername }},
+	{"smtp-password", "SMTP_PASSWORD", "SMTP password", func(c *Config) any { return &c.Mail.SMTPPassword }},
+	{"log-format", "CHIRPY_LOG_FORMAT", "Log output format: text or json", func(c *Config) any { return &c.Log.Format }},
+	{"log-level", "CHIRPY_LOG_LEVEL", "Lowest level logged: debug, info, warn or error", func(c *Config) any { return &c.Log.Level }},
+}
+
+// loadConfig builds the Config from args (without the program name), the environment and an optional file
+func loadConfig(args []string) (Config, error) {
+	config := defaultConfig()
+
+	fs := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CHIRPY_CONFIG"), "Path to a YAML config file (env CHIRPY_CONFIG)")
+
+	// flags are only recorded here and applied last, so they override the file and environment
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		name := s.flag
+		record := func(value string) error {
+			flagValues[name] = value
+			return nil
+		}
+		usage := fmt.Sprintf("%s (env %s, default %v)", s.usage, s.env, displayValue(s.field(&config)))
+		if _, isBool := s.field(&config).(*bool); isBool {
+			fs.BoolFunc(name, usage, record)
+		} else {
+			fs.Func(name, usage, record)
+		}
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if *configPath != "" {
+		err = loadConfigFile(*configPath, &config)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			err = setValue(s.field(&config), value)
+			if err != nil {
+				return Config{}, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := flagValues[s.flag]; ok {
+			err = setValue(s.field(&config), value)
+			if err != nil {
+				return Config{}, fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if config.Database.Path == "" {
+		config.Database.Path = "./chirpy_database." + config.Database.Driver
+	}
+	if config.Server.PublicURL == "" {
+		config.Server.PublicURL = "http://" + net.JoinHostPort(config.Server.Host, strconv.Itoa(config.Server.Port))
+	}
+	config.Server.PublicURL = strings.TrimSuffix(config.Server.PublicURL, "/")
+
+	return config, config.validate()
+}
+
+// loadConfigFile reads the YAML file at path over the values already in config
+func loadConfigFile(path string, config *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil {
+		return fmt.Errorf("reading config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// setValue parses value into the Config field that ptr points at
+func setValue(ptr any, value string) error {
+	switch field := ptr.(type) {
+	case *string:
+		*field = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field = d
+	case *[]string:
+		*field = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return nil
+}
+
+// displayValue dereferences a Config field pointer for display
+func displayValue(ptr any) any {
+	switch field := ptr.(type) {
+	case *string:
+		return fmt.Sprintf("%q", *field)
+	case *int:
+		return *field
+	case *bool:
+		return *field
+	case *time.Duration:
+		return *field
+	case *[]string:
+		return fmt.Sprintf("%q", strings.Join(*field, ","))
+	default:
+		return nil
+	}
+}
+
+// minJWTSecretLength is the shortest JWT secret accepted, in bytes; HS256 keys should be at least as long as its hash
+const minJWTSecretLength = 32
+
+// validate reports every setting that would stop chirpy from running correctly
+func (c Config) validate() error {
+	errs := []error{}
+	if c.Auth.JWTSecret == "" {
+		errs = append(errs, errors.New("JWT_SECRET must be set, refusing to sign tokens with an empty key"))
+	} else if len(c.Auth.JWTSecret) < minJWTSecretLength {
+		errs = append(errs, fmt.Errorf("JWT_SECRET is %d bytes, it must be at least %d (openssl rand -base64 32)", len(c.Auth.JWTSecret), minJWTSecretLength))
+	}
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range", c.Server.Port))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be positive"))
+	}
+	if c.Database.Driver != database.DriverJSON && c.Database.Driver != database.DriverSQLite {
+		errs = append(errs, fmt.Errorf("unknown database driver %q, must be json or sqlite", c.Database.Driver))
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		errs = append(errs, errors.New("access token TTL must be positive"))
+	}
+	if c.Auth.RefreshTokenTTL <= c.Auth.AccessTokenTTL {
+		errs = append(errs, errors.New("refresh token TTL must be longer than the access token TTL"))
+	}
+	if c.Chirps.MaxLength < 1 {
+		errs = append(errs, errors.New("max chirp length must be at least 1"))
+	}
+	_, err := newLogger(io.Discard, c.Log.Format, c.Log.Level)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	_, err = mailer.New(c.mailerConfig())
+	if err != nil {
+		errs = append(errs, err)
+	}
+	_, err = c.totpKey()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// totpKey returns the key that encrypts TOTP secrets.
+// Without a configured key one is derived from the JWT secret, which means
+// changing the JWT secret locks out every user with two-factor authentication on.
+func (c Config) totpKey() ([]byte, error) {
+	if c.Auth.TOTPKey == "" {
+		sum := sha256.Sum256([]byte("chirpy-totp-key:" + c.Auth.JWTSecret))
+		return sum[:], nil
+	}
+	key, err := base64.StdEncoding.DecodeString(c.Auth.TOTPKey)
+	if err != nil {
+		return nil, fmt.Errorf("totp key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("totp key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// defaultDebugMailDir is where emails are written with --debug when no mail driver is set
+const defaultDebugMailDir = "mail"
+
+// mailerConfig returns the settings for mailer.New
+func (c Config) mailerConfig() mailer.Config {
+	driver := c.Mail.Driver
+	dir := c.Mail.Dir
+	if driver == "" {
+		// don't send mail anywhere the server wasn't told to
+		driver = mailer.DriverNone
+		if c.Server.Debug {
+			// the log driver leaves out bodies, and with them the links a developer needs to follow
+			driver = mailer.DriverFile
+			if dir == "" {
+				dir = defaultDebugMailDir
+			}
+		}
+	}
+	return mailer.Config{
+		Driver:       driver,
+		From:         c.Mail.From,
+		Dir:          dir,
+		SMTPHost:     c.Mail.SMTPHost,
+		SMTPPort:     c.Mail.SMTPPort,
+		SMTPUsername: c.Mail.SMTPUsername,
+		SMTPPassword: c.Mail.SMTPPassword,
+	}
+}
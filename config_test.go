@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/mailer"
+)
+
+func TestMailerConfigDefaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		debug      bool
+		driver     string
+		dir        string
+		wantDriver string
+		wantDir    string
+	}{
+		{"nothing set", false, "", "", mailer.DriverNone, ""},
+		// bodies hold the links a developer needs, which the log driver leaves out
+		{"debug", true, "", "", mailer.DriverFile, defaultDebugMailDir},
+		{"debug with a directory", true, "", "/tmp/mail", mailer.DriverFile, "/tmp/mail"},
+		{"debug with a driver", true, mailer.DriverLog, "", mailer.DriverLog, ""},
+		{"driver without debug", false, mailer.DriverFile, "out", mailer.DriverFile, "out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.Server.Debug = tt.debug
+			config.Mail.Driver = tt.driver
+			config.Mail.Dir = tt.dir
+			got := config.mailerConfig()
+			if got.Driver != tt.wantDriver || got.Dir != tt.wantDir {
+				t.Errorf("driver %q dir %q, want %q in %q", got.Driver, got.Dir, tt.wantDriver, tt.wantDir)
+			}
+		})
+	}
+}
+
+// testJWTSecret is long enough to pass validation
+const testJWTSecret = "0123456789abcdef0123456789abcdef"
+
+// loadTestConfig runs loadConfig with args, env as the only chirpy environment variables and,
+// if it isn't empty, yamlConfig as the config file
+func loadTestConfig(t *testing.T, args []string, env map[string]string, yamlConfig string) (Config, error) {
+	t.Helper()
+	// an empty variable counts as unset, which keeps the developer's own settings out
+	t.Setenv("CHIRPY_CONFIG", "")
+	for _, s := range settings {
+		t.Setenv(s.env, "")
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	if yamlConfig != "" {
+		path := filepath.Join(t.TempDir(), "chirpy.yaml")
+		err := os.WriteFile(path, []byte(yamlConfig), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"--config", path}, args...)
+	}
+	return loadConfig(args)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	secret := map[string]string{"JWT_SECRET": testJWTSecret}
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		yaml  string
+		check func(t *testing.T, config Config)
+	}{
+		{"defaults", nil, secret, "", func(t *testing.T, config Config) {
+			if config.Server.Port != 8080 || config.Chirps.MaxLength != defaultMaxChirpLength || config.Auth.AccessTokenTTL != time.Hour {
+				t.Errorf("port %d max length %d access TTL %s, want the defaults", config.Server.Port, config.Chirps.MaxLength, config.Auth.AccessTokenTTL)
+			}
+			if config.Database.Path != "./chirpy_database.json" || config.Server.PublicURL != "http://localhost:8080" {
+				t.Errorf("database path %q public URL %q, want them derived from the defaults", config.Database.Path, config.Server.PublicURL)
+			}
+		}},
+		{"file over defaults", nil, secret, "server:\n  port: 9000\nchirps:\n  max_length: 280\n", func(t *testing.T, config Config) {
+			if config.Server.Port != 9000 || config.Chirps.MaxLength != 280 {
+				t.Errorf("port %d max length %d, want 9000 and 280 from the file", config.Server.Port, config.Chirps.MaxLength)
+			}
+			// settings the file leaves out keep their defaults
+			if config.Server.Host != "localhost" {
+				t.Errorf("host %q, want the default", config.Server.Host)
+			}
+		}},
+		{"environment over file", nil, map[string]string{"JWT_SECRET": testJWTSecret, "CHIRPY_PORT": "9001"}, "server:\n  port: 9000\nchirps:\n  max_length: 280\n", func(t *testing.T, config Config) {
+			if config.Server.Port != 9001 || config.Chirps.MaxLength != 280 {
+				t.Errorf("port %d max length %d, want 9001 from the environment and 280 from the file", config.Server.Port, config.Chirps.MaxLength)
+			}
+		}},
+		{"flag over environment and file", []string{"--port", "9002"}, map[string]string{"JWT_SECRET": testJWTSecret, "CHIRPY_PORT": "9001"}, "server:\n  port: 9000\n", func(t *testing.T, config Config) {
+			if config.Server.Port != 9002 {
+				t.Errorf("port %d, want 9002 from the flag", config.Server.Port)
+			}
+		}},
+		{"secret from the file", nil, nil, "auth:\n  jwt_secret: " + testJWTSecret + "\n", func(t *testing.T, config Config) {
+			if config.Auth.JWTSecret != testJWTSecret {
+				t.Errorf("JWT secret %q, want the one in the file", config.Auth.JWTSecret)
+			}
+		}},
+		{"types", []string{"--debug", "--access-token-ttl", "15m", "--admin-emails", " a@example.com, ,b@example.com"}, secret, "", func(t *testing.T, config Config) {
+			if !config.Server.Debug || config.Auth.AccessTokenTTL != 15*time.Minute {
+				t.Errorf("debug %v access TTL %s, want true and 15m", config.Server.Debug, config.Auth.AccessTokenTTL)
+			}
+			if !reflect.DeepEqual(config.Auth.AdminEmails, []string{"a@example.com", "b@example.com"}) {
+				t.Errorf("admin emails %q, want the two listed", config.Auth.AdminEmails)
+			}
+		}},
+		{"derived settings", []string{"--db-driver", "sqlite", "--public-url", "https://chirpy.example.com/"}, secret, "", func(t *testing.T, config Config) {
+			if config.Database.Path != "./chirpy_database.sqlite" || config.Server.PublicURL != "https://chirpy.example.com" {
+				t.Errorf("database path %q public URL %q, want the sqlite default and no trailing slash", config.Database.Path, config.Server.PublicURL)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadTestConfig(t, tt.args, tt.env, tt.yaml)
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestLoadConfigPathFromEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirpy.yaml")
+	err := os.WriteFile(path, []byte("server:\n  port: 9003\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadTestConfig(t, nil, map[string]string{"JWT_SECRET": testJWTSecret, "CHIRPY_CONFIG": path}, "")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if config.Server.Port != 9003 {
+		t.Errorf("port %d, want 9003 from the file named by CHIRPY_CONFIG", config.Server.Port)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		yaml string
+		// want holds text every error must contain
+		want []string
+	}{
+		{"missing JWT secret", nil, map[string]string{"JWT_SECRET": ""}, "", []string{"JWT_SECRET must be set"}},
+		{"short JWT secret", nil, map[string]string{"JWT_SECRET": "too-short"}, "", []string{"JWT_SECRET is 9 bytes, it must be at least 32"}},
+		{"short JWT secret in the file", nil, map[string]string{"JWT_SECRET": ""}, "auth:\n  jwt_secret: short\n", []string{"JWT_SECRET is 5 bytes"}},
+		{"unknown mail driver", []string{"--mail-driver", "pigeon"}, nil, "", []string{`unknown mailer driver "pigeon"`}},
+		{"file mail driver without a directory", []string{"--mail-driver", "file"}, nil, "", []string{"file mailer needs a directory"}},
+		{"smtp mail driver without a host", []string{"--mail-driver", "smtp"}, nil, "", []string{"smtp mailer needs a host"}},
+		{"unknown database driver", []string{"--db-driver", "postgres"}, nil, "", []string{`unknown database driver "postgres"`}},
+		{"port out of range", []string{"--port", "70000"}, nil, "", []string{"port 70000 is out of range"}},
+		{"max chirp length", []string{"--max-chirp-length", "0"}, nil, "", []string{"max chirp length must be at least 1"}},
+		{"shutdown timeout", []string{"--shutdown-timeout", "0s"}, nil, "", []string{"shutdown timeout must be positive"}},
+		{"access token TTL", []string{"--access-token-ttl", "-1m"}, nil, "", []string{"access token TTL must be positive"}},
+		{"refresh token TTL", []string{"--refresh-token-ttl", "30m"}, nil, "", []string{"refresh token TTL must be longer"}},
+		{"log level", []string{"--log-level", "loud"}, nil, "", []string{"loud"}},
+		{"TOTP key", []string{"--totp-key", "c2hvcnQ="}, nil, "", []string{"totp key must be 32 bytes"}},
+		{"every problem is reported", []string{"--port", "0", "--max-chirp-length", "0"}, map[string]string{"JWT_SECRET": ""}, "", []string{"JWT_SECRET must be set", "port 0 is out of range", "max chirp length"}},
+		{"bad flag value", []string{"--port", "eighty"}, nil, "", []string{"flag -port"}},
+		{"bad environment value", nil, map[string]string{"CHIRPY_PORT": "eighty"}, "", []string{"environment variable CHIRPY_PORT"}},
+		{"unknown flag", []string{"--colour"}, nil, "", []string{"colour"}},
+		{"unknown field in the file", nil, nil, "server:\n  colour: blue\n", []string{"reading config file", "colour"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// valid unless the case says otherwise; an empty value unsets a variable
+			env := map[string]string{"JWT_SECRET": testJWTSecret}
+			for name, value := range tt.env {
+				env[name] = value
+			}
+			_, err := loadTestConfig(t, tt.args, env, tt.yaml)
+			if err == nil {
+				t.Fatal("loadConfig succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %q", err, want)
+				}
+			}
+		})
+	}
+}
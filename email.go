@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/mailer"
+)
+
+// maxEmailLength is the longest address SMTP can deliver to
+const maxEmailLength = 254
+
+// purposeVerifyEmail is the one-time token purpose of email verification links
+const purposeVerifyEmail = "chirpy-verify-email"
+
+// verificationTTL is how long a verification link works
+const verificationTTL = 24 * time.Hour
+
+// validateEmail checks that email is a bare address like user@example.com
+// and returns it lowercased with surrounding whitespace removed, the form the store keeps
+func validateEmail(email string) (string, bool) {
+	email = strings.TrimSpace(email)
+	if email == "" || len(email) > maxEmailLength {
+		return "", false
+	}
+
+	// ParseAddress also accepts forms like "Name <user@example.com>", which aren't an address on their own
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", false
+	}
+
+	_, domain, _ := strings.Cut(email, "@")
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", false
+	}
+	return strings.ToLower(email), true
+}
+
+// sendVerificationEmail emails user a link that verifies their address
+func (cfg *apiConfig) sendVerificationEmail(user database.User) error {
+	token, record, err := cfg.newOneTimeToken(purposeVerifyEmail, user, verificationTTL, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	err = cfg.chirpyDatabase.CreateOneTimeToken(record)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.publicURL + "/api/users/verify?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\nOpen this link to verify your email address:\n\n%s\n\n"+
+			"The link works once and expires in %s. If you didn't sign up for Chirpy, ignore this email.\n",
+			link, verificationTTL),
+	})
+}
+
+// middlewareRequireVerifiedEmail blocks users who haven't verified their email address
+// when the server requires it. It must run after middlewareRequireAuth.
+func (cfg *apiConfig) middlewareRequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.requireVerifiedEmail {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger := requestLogger(r)
+
+		userID, ok := userIDFromContext(r.Context())
+		if !ok {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+			return
+		}
+
+		user, err := cfg.chirpyDatabase.GetUser(userID)
+		if errors.Is(err, database.ErrNotExist) {
+			logger.Info("Authenticated user no longer exists")
+			respondWithError(w, http.StatusUnauthorized, "User no longer exists")
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to look up user", "err", err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't look up user")
+			return
+		}
+
+		if !user.Verified {
+			logger.Info("Unverified user blocked")
+			respondWithError(w, http.StatusForbidden, "Verify your email address first")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
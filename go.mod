@@ -9,3 +9,24 @@ require (
 )
 
 require github.com/golang-jwt/jwt/v5 v5.2.0
+
+require github.com/mattn/go-sqlite3 v1.14.22
+
+require golang.org/x/text v0.14.0
+
+require github.com/rivo/uniseg v0.4.7
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/prometheus/client_golang v1.19.1
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
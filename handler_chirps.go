@@ -2,52 +2,237 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/hashtag"
 	"github.com/go-chi/chi/v5"
 )
 
+type Chirp struct {
+	// the key will be the name of struct field unless you give it an explicit JSON tag
+	ID          int    `json:"id"`
+	Body        string `json:"body"`
+	AuthorID    int    `json:"author_id"`
+	InReplyToID int    `json:"in_reply_to_id,omitempty"`
+	RechirpOfID int    `json:"rechirp_of_id,omitempty"`
+	QuoteOfID   int    `json:"quote_of_id,omitempty"`
+	ReplyCount  int    `json:"reply_count"`
+	LikeCount   int    `json:"like_count"`
+	// LikedByMe is only set when the caller is authenticated
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// Deleted is only set on the tombstones that stand in for deleted chirps in threads
+	Deleted bool `json:"deleted,omitempty"`
+	// Original is the chirp this one rechirps or quotes. It is left out once that chirp is deleted.
+	Original *Chirp        `json:"original,omitempty"`
+	Entities ChirpEntities `json:"entities"`
+}
+
+// ChirpEntities describes the parts of a chirp's body that clients may want to link
+type ChirpEntities struct {
+	Hashtags []HashtagEntity `json:"hashtags"`
+}
+
+// HashtagEntity is one hashtag in a chirp's body. The offsets cover the '#' and are half-open,
+// counted in bytes of the UTF-8 body and in code points, so clients in any language can use them.
+type HashtagEntity struct {
+	// Text is the hashtag as written, without the '#'
+	Text string `json:"text"`
+	// Tag is the normalized form that GET /api/tags/{tag}/chirps matches
+	Tag       string `json:"tag"`
+	ByteStart int    `json:"byte_start"`
+	ByteEnd   int    `json:"byte_end"`
+	RuneStart int    `json:"rune_start"`
+	RuneEnd   int    `json:"rune_end"`
+}
+
+// chirpEntities finds the entities in a chirp body
+func chirpEntities(body string) ChirpEntities {
+	entities := ChirpEntities{Hashtags: []HashtagEntity{}}
+	for _, tag := range hashtag.Extract(body) {
+		entities.Hashtags = append(entities.Hashtags, HashtagEntity{
+			Text:      tag.Text,
+			Tag:       tag.Tag,
+			ByteStart: tag.ByteStart,
+			ByteEnd:   tag.ByteEnd,
+			RuneStart: tag.RuneStart,
+			RuneEnd:   tag.RuneEnd,
+		})
+	}
+	return entities
+}
+
+// chirpResponse converts a stored chirp into the form the API returns,
+// leaving out fields only moderators should see
+func chirpResponse(chirp database.Chirp) Chirp {
+	return Chirp{
+		ID:          chirp.ID,
+		Body:        chirp.Body,
+		AuthorID:    chirp.AuthorID,
+		InReplyToID: chirp.InReplyToID,
+		RechirpOfID: chirp.RechirpOfID,
+		QuoteOfID:   chirp.QuoteOfID,
+		ReplyCount:  chirp.ReplyCount,
+		LikeCount:   chirp.LikeCount,
+		Deleted:     chirp.Deleted,
+		Entities:    chirpEntities(chirp.Body),
+	}
+}
+
+func chirpsResponse(chirps []database.Chirp) []Chirp {
+	response := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		response = append(response, chirpResponse(chirp))
+	}
+	return response
+}
+
+// renderChirps converts chirps into the form the API returns, embedding the chirps they rechirp or quote.
+// When the request is authenticated it also fills in whether the caller likes each of them.
+func (cfg *apiConfig) renderChirps(req *http.Request, chirps []database.Chirp) ([]Chirp, error) {
+	originalIDs := []int{}
+	for _, chirp := range chirps {
+		if chirp.RechirpOfID != 0 {
+			originalIDs = append(originalIDs, chirp.RechirpOfID)
+		}
+		if chirp.QuoteOfID != 0 {
+			originalIDs = append(originalIDs, chirp.QuoteOfID)
+		}
+	}
+	originals := []database.Chirp{}
+	if len(originalIDs) > 0 {
+		var err error
+		originals, err = cfg.chirpyDatabase.GetChirps(database.ChirpQuery{IDs: originalIDs})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the page and the originals are rendered together so one lookup covers the caller's likes
+	rendered := chirpsResponse(append(append([]database.Chirp{}, chirps...), originals...))
+
+	viewerID, ok := userIDFromContext(req.Context())
+	if ok {
+		ids := make([]int, 0, len(rendered))
+		for _, chirp := range rendered {
+			ids = append(ids, chirp.ID)
+		}
+		liked, err := cfg.chirpyDatabase.GetLikedChirpIDs(viewerID, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rendered {
+			likedByMe := liked[rendered[i].ID]
+			rendered[i].LikedByMe = &likedByMe
+		}
+	}
+
+	response, renderedOriginals := rendered[:len(chirps)], rendered[len(chirps):]
+	originalsByID := make(map[int]*Chirp, len(renderedOriginals))
+	for i := range renderedOriginals {
+		originalsByID[renderedOriginals[i].ID] = &renderedOriginals[i]
+	}
+	for i := range response {
+		originalID := response[i].RechirpOfID
+		if originalID == 0 {
+			originalID = response[i].QuoteOfID
+		}
+		// deleted originals are missing, so the chirp keeps only the ID
+		if original, exists := originalsByID[originalID]; exists {
+			response[i].Original = original
+		}
+	}
+	return response, nil
+}
+
 func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, req *http.Request) {
 
-	chirps, err := cfg.chirpyDatabase.GetChirps()
+	logger := requestLogger(req)
+
+	query, err := parseChirpQuery(req)
+	if err != nil {
+		logger.Info("Invalid chirps query", "err", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if authorID := req.URL.Query().Get("author_id"); authorID != "" {
+		query.AuthorID, err = strconv.Atoi(authorID)
+		if err != nil || query.AuthorID < 1 {
+			logger.Info("Invalid author_id", "author_id", authorID)
+			respondWithError(w, http.StatusBadRequest, "author_id must be a user ID")
+			return
+		}
+	}
+
+	chirps, err := cfg.chirpyDatabase.GetChirps(peekQuery(query))
 	if err != nil {
-		log.Printf("Failed to get chirps with error: %s", err)
+		logger.Error("Failed to get chirps", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	response, err := cfg.renderChirps(req, paginate(w, req, query.Limit, chirps, chirpCursor))
+	if err != nil {
+		logger.Error("Failed to render chirps", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
 
 }
 
 func (cfg *apiConfig) getChirpHandler(w http.ResponseWriter, req *http.Request) {
+	logger := requestLogger(req)
+
 	id, err := strconv.Atoi(chi.URLParam(req, "chirpID"))
 	if err != nil {
-		log.Printf("Failed to get chirp ID from request with error: %s", err)
+		logger.Info("Invalid chirp ID in request", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp ID")
 		return
 	}
 
-	chirp, exists := cfg.chirpyDatabase.Data.Chirps[id]
-	if !exists {
-		log.Printf("Chirp ID %v does not exist", id)
+	chirp, err := cfg.chirpyDatabase.GetChirp(id)
+	if errors.Is(err, database.ErrNotExist) || (err == nil && chirp.Deleted) {
+		logger.Info("Chirp does not exist", "chirp_id", id)
 		respondWithError(w, http.StatusNotFound, "Chirp ID requested does not exist")
 		return
 	}
+	if err != nil {
+		logger.Error("Failed to get chirp", "chirp_id", id, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp")
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, chirp)
+	response, err := cfg.renderChirps(req, []database.Chirp{chirp})
+	if err != nil {
+		logger.Error("Failed to render chirps", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response[0])
 
 }
 
 func (cfg *apiConfig) postChirpHandler(w http.ResponseWriter, req *http.Request) {
 
+	logger := requestLogger(req)
+
+	authorID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
 	type parameters struct {
 		// these tags indicate how the keys in the JSON should be mapped to the struct fields
 		// the struct fields must be exported (start with a capital letter) if you want them parsed
-		Body string `json:"body"`
+		Body        string `json:"body"`
+		InReplyToID int    `json:"in_reply_to_id"`
+		QuoteOfID   int    `json:"quote_of_id"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -56,37 +241,158 @@ func (cfg *apiConfig) postChirpHandler(w http.ResponseWriter, req *http.Request)
 	if err != nil {
 		// an error will be thrown if the JSON is invalid or has the wrong types
 		// any missing fields will simply have their values in the struct set to their zero value
-		log.Printf("Error decoding parameters: %s", err)
+		logger.Info("Error decoding parameters", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
 
-	if len(params.Body) > 140 {
-		log.Printf("Chirp is longer than 140 characters. Chirp length: %v", len(params.Body))
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	body, bodyErr := cfg.normalizeChirpBody(params.Body)
+	if bodyErr != nil {
+		logger.Info("Invalid chirp", "reason", bodyErr.Message)
+		respondWithJSON(w, http.StatusBadRequest, bodyErr)
 		return
 	}
 
-	bodySplit := strings.Split(params.Body, " ")
-	badWords := map[string]bool{
-		"kerfuffle": true,
-		"sharbert":  true,
-		"fornax":    true}
-	for i, word := range bodySplit {
-		if badWords[strings.ToLower(word)] {
-			bodySplit[i] = "****"
+	if params.InReplyToID != 0 {
+		available, err := cfg.chirpAvailable(params.InReplyToID)
+		if err != nil {
+			logger.Error("Failed to get parent chirp", "in_reply_to_id", params.InReplyToID, "err", err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create new chirp")
+			return
+		}
+		if !available {
+			logger.Info("Reply to a chirp that does not exist", "in_reply_to_id", params.InReplyToID)
+			respondWithError(w, http.StatusBadRequest, "The chirp you're replying to does not exist")
+			return
+		}
+	}
+
+	if params.QuoteOfID != 0 {
+		available, err := cfg.chirpAvailable(params.QuoteOfID)
+		if err != nil {
+			logger.Error("Failed to get quoted chirp", "quote_of_id", params.QuoteOfID, "err", err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create new chirp")
+			return
+		}
+		if !available {
+			logger.Info("Quote of a chirp that does not exist", "quote_of_id", params.QuoteOfID)
+			respondWithError(w, http.StatusBadRequest, "The chirp you're quoting does not exist")
+			return
 		}
 	}
 
-	bodyClean := strings.Join(bodySplit, " ")
+	moderated := cfg.moderator.Moderate(body)
+	if moderated.Rejected {
+		logger.Info("Chirp rejected by moderation rule", "rule", moderated.RejectedBy)
+		respondWithError(w, http.StatusBadRequest, "Chirp contains content that is not allowed")
+		return
+	}
+	if bodyErr := cfg.checkChirpLength(moderated.Body); bodyErr != nil {
+		logger.Info("Chirp too long once masked", "length", bodyErr.Length)
+		bodyErr.Message = fmt.Sprintf("Chirp is too long once masked, the limit is %d characters", cfg.maxChirpLength)
+		respondWithJSON(w, http.StatusBadRequest, bodyErr)
+		return
+	}
+	if moderated.Flagged {
+		logger.Info("Chirp flagged for review by moderation rules", "rules", moderated.FlaggedBy)
+	}
 
-	newChirp, err := cfg.chirpyDatabase.CreateChirp(bodyClean)
+	newChirp, err := cfg.chirpyDatabase.CreateChirp(database.Chirp{
+		Body:        moderated.Body,
+		AuthorID:    authorID,
+		Flagged:     moderated.Flagged,
+		InReplyToID: params.InReplyToID,
+		QuoteOfID:   params.QuoteOfID,
+		// tags come from the body as stored, after moderation has masked anything
+		Tags: hashtag.Tags(moderated.Body),
+	})
+	if errors.Is(err, database.ErrNotExist) {
+		// the author, or a chirp this one refers to, was deleted since they were checked
+		logger.Warn("Author or referenced chirp of new chirp no longer exists")
+		respondWithError(w, http.StatusConflict, "Couldn't create new chirp, the user or the chirp it refers to no longer exists")
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to create new chirp with error: %s", err)
+		logger.Error("Failed to create new chirp", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create new chirp")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, newChirp)
+	cfg.respondWithNewChirp(w, req, newChirp)
+
+}
+
+// respondWithNewChirp answers a request that created chirp, with the chirp it quotes or rechirps embedded
+func (cfg *apiConfig) respondWithNewChirp(w http.ResponseWriter, req *http.Request, chirp database.Chirp) {
+	response, err := cfg.renderChirps(req, []database.Chirp{chirp})
+	if err != nil {
+		// the chirp was saved, so still report it
+		requestLogger(req).Error("Failed to render new chirp", "chirp_id", chirp.ID, "err", err)
+		respondWithJSON(w, http.StatusCreated, chirpResponse(chirp))
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response[0])
+}
+
+// chirpAvailable reports whether the chirp with the given ID exists and is not a tombstone
+func (cfg *apiConfig) chirpAvailable(id int) (bool, error) {
+	chirp, err := cfg.chirpyDatabase.GetChirp(id)
+	if errors.Is(err, database.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !chirp.Deleted, nil
+}
+
+func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(req, "chirpID"))
+	if err != nil {
+		logger.Info("Invalid chirp ID in request", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't get chirp ID")
+		return
+	}
+
+	chirp, err := cfg.chirpyDatabase.GetChirp(id)
+	if errors.Is(err, database.ErrNotExist) || (err == nil && chirp.Deleted) {
+		logger.Info("Chirp does not exist", "chirp_id", id)
+		respondWithError(w, http.StatusNotFound, "Chirp ID requested does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to get chirp", "chirp_id", id, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp")
+		return
+	}
+
+	if chirp.AuthorID != userID {
+		logger.Warn("Attempted to delete another user's chirp", "chirp_id", id, "author_id", chirp.AuthorID)
+		respondWithError(w, http.StatusForbidden, "You can only delete your own chirps")
+		return
+	}
+
+	err = cfg.chirpyDatabase.DeleteChirp(id)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Chirp no longer exists", "chirp_id", id)
+		respondWithError(w, http.StatusNotFound, "Chirp ID requested does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to delete chirp", "chirp_id", id, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (cfg *apiConfig) databaseResetHandler(w http.ResponseWriter, req *http.Request) {
+	logger := requestLogger(req)
+
+	err := cfg.chirpyDatabase.Reset()
+	if err != nil {
+		logger.Error("Failed to reset database", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset database")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte("Database has been reset"))
+}
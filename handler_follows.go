@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// postFollowHandler makes the authenticated user follow {userID}. Following twice is not an error.
+func (cfg *apiConfig) postFollowHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	followerID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	followeeID, err := strconv.Atoi(chi.URLParam(req, "userID"))
+	if err != nil {
+		logger.Info("Invalid user ID in request", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't get user ID")
+		return
+	}
+
+	if followeeID == followerID {
+		logger.Info("User attempted to follow themselves")
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+		return
+	}
+
+	err = cfg.chirpyDatabase.Follow(followerID, followeeID, time.Now().UTC())
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("User to follow does not exist", "followee_id", followeeID)
+		respondWithError(w, http.StatusNotFound, "User does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to follow user", "followee_id", followeeID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
+
+// deleteFollowHandler makes the authenticated user stop following {userID}. Unfollowing twice is not an error.
+func (cfg *apiConfig) deleteFollowHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	followerID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	followeeID, err := strconv.Atoi(chi.URLParam(req, "userID"))
+	if err != nil {
+		logger.Info("Invalid user ID in request", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't get user ID")
+		return
+	}
+
+	err = cfg.chirpyDatabase.Unfollow(followerID, followeeID)
+	if err != nil {
+		logger.Error("Failed to unfollow user", "followee_id", followeeID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
+
+// getFollowersHandler lists the users following {userID}
+func (cfg *apiConfig) getFollowersHandler(w http.ResponseWriter, req *http.Request) {
+	cfg.listFollows(w, req, cfg.chirpyDatabase.GetFollowers)
+}
+
+// getFollowingHandler lists the users {userID} follows
+func (cfg *apiConfig) getFollowingHandler(w http.ResponseWriter, req *http.Request) {
+	cfg.listFollows(w, req, cfg.chirpyDatabase.GetFollowing)
+}
+
+// listFollows responds with the page of users that list returns for {userID}
+func (cfg *apiConfig) listFollows(w http.ResponseWriter, req *http.Request, list func(userID int, query database.UserQuery) ([]database.User, error)) {
+
+	logger := requestLogger(req)
+
+	userID, err := strconv.Atoi(chi.URLParam(req, "userID"))
+	if err != nil {
+		logger.Info("Invalid user ID in request", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't get user ID")
+		return
+	}
+
+	query, err := parseUserQuery(req)
+	if err != nil {
+		logger.Info("Invalid users query", "err", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, err = cfg.chirpyDatabase.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("User does not exist", "target_user_id", userID)
+		respondWithError(w, http.StatusNotFound, "User does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to look up user", "target_user_id", userID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get users")
+		return
+	}
+
+	users, err := list(userID, peekUserQuery(query))
+	if err != nil {
+		logger.Error("Failed to list follows", "target_user_id", userID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get users")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profilesResponse(paginate(w, req, query.Limit, users, userCursor)))
+
+}
+
+// getTimelineHandler lists chirps by the authenticated user and the users they follow, newest first unless ?sort=asc
+func (cfg *apiConfig) getTimelineHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	query, err := parseChirpQuery(req)
+	if err != nil {
+		logger.Info("Invalid timeline query", "err", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.URL.Query().Get("sort") == "" {
+		query.Desc = true
+	}
+	query.TimelineOf = userID
+
+	chirps, err := cfg.chirpyDatabase.GetChirps(peekQuery(query))
+	if err != nil {
+		logger.Error("Failed to get timeline", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get timeline")
+		return
+	}
+
+	response, err := cfg.renderChirps(req, paginate(w, req, query.Limit, chirps, chirpCursor))
+	if err != nil {
+		logger.Error("Failed to render chirps", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get timeline")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+
+}
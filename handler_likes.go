@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// postLikeHandler makes the authenticated user like {chirpID}. Liking twice is not an error.
+func (cfg *apiConfig) postLikeHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(req, "chirpID"))
+	if err != nil {
+		logger.Info("Invalid chirp ID", "chirp_id", chi.URLParam(req, "chirpID"))
+		respondWithError(w, http.StatusBadRequest, "Chirp ID must be a number")
+		return
+	}
+
+	err = cfg.chirpyDatabase.LikeChirp(userID, chirpID, time.Now().UTC())
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Chirp to like does not exist", "chirp_id", chirpID)
+		respondWithError(w, http.StatusNotFound, "Chirp ID requested does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to like chirp", "chirp_id", chirpID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
+
+// deleteLikeHandler makes the authenticated user stop liking {chirpID}. Unliking twice is not an error.
+func (cfg *apiConfig) deleteLikeHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(req, "chirpID"))
+	if err != nil {
+		logger.Info("Invalid chirp ID", "chirp_id", chi.URLParam(req, "chirpID"))
+		respondWithError(w, http.StatusBadRequest, "Chirp ID must be a number")
+		return
+	}
+
+	err = cfg.chirpyDatabase.UnlikeChirp(userID, chirpID)
+	if err != nil {
+		logger.Error("Failed to unlike chirp", "chirp_id", chirpID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlike chirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
+
+// getUserLikesHandler lists the chirps {userID} likes, newest first unless ?sort=asc is given
+func (cfg *apiConfig) getUserLikesHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, err := strconv.Atoi(chi.URLParam(req, "userID"))
+	if err != nil {
+		logger.Info("Invalid user ID in request", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't get user ID")
+		return
+	}
+
+	query, err := parseChirpQuery(req)
+	if err != nil {
+		logger.Info("Invalid likes query", "err", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.URL.Query().Get("sort") == "" {
+		query.Desc = true
+	}
+	query.LikedBy = userID
+
+	_, err = cfg.chirpyDatabase.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("User does not exist", "target_user_id", userID)
+		respondWithError(w, http.StatusNotFound, "User does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to look up user", "target_user_id", userID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes")
+		return
+	}
+
+	chirps, err := cfg.chirpyDatabase.GetChirps(peekQuery(query))
+	if err != nil {
+		logger.Error("Failed to get liked chirps", "target_user_id", userID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes")
+		return
+	}
+
+	response, err := cfg.renderChirps(req, paginate(w, req, query.Limit, chirps, chirpCursor))
+	if err != nil {
+		logger.Error("Failed to render chirps", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+
+}
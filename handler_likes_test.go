@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+// likedByMeJSON returns the liked_by_me of chirp as JSON, "" when it was left out
+func likedByMeJSON(chirp Chirp) string {
+	if chirp.LikedByMe == nil {
+		return ""
+	}
+	return fmt.Sprint(*chirp.LikedByMe)
+}
+
+func TestLikedByMe(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			fan, err := cfg.chirpyDatabase.CreateUser("fan@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			bystander, err := cfg.chirpyDatabase.CreateUser("bystander@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			chirp, err := cfg.chirpyDatabase.CreateChirp(database.Chirp{Body: "likeable", AuthorID: bystander.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// like calls handler on the chirp's likes as the fan
+			like := func(handler http.HandlerFunc, method string) {
+				t.Helper()
+				w := callRoute(t, "/api/chirps/{chirpID}/likes", handler, method, fmt.Sprintf("/api/chirps/%d/likes", chirp.ID), fan.ID, nil)
+				if w.Code != http.StatusNoContent {
+					t.Fatalf("%s like: status %d: %s", method, w.Code, w.Body)
+				}
+			}
+			like(cfg.postLikeHandler, http.MethodPost)
+			like(cfg.postLikeHandler, http.MethodPost)
+
+			tests := []struct {
+				name   string
+				userID int
+				// want is liked_by_me as JSON, "" when it should be left out
+				want string
+			}{
+				{"liker", fan.ID, "true"},
+				{"someone else", bystander.ID, "false"},
+				{"signed out", 0, ""},
+			}
+			for _, tt := range tests {
+				got := getChirp(t, cfg, chirp.ID, tt.userID)
+				if got.LikeCount != 1 {
+					t.Errorf("%s: like count %d, want 1", tt.name, got.LikeCount)
+				}
+				if likedByMe := likedByMeJSON(got); likedByMe != tt.want {
+					t.Errorf("%s: liked_by_me %q, want %q", tt.name, likedByMe, tt.want)
+				}
+			}
+
+			like(cfg.deleteLikeHandler, http.MethodDelete)
+			like(cfg.deleteLikeHandler, http.MethodDelete)
+			got := getChirp(t, cfg, chirp.ID, fan.ID)
+			if got.LikeCount != 0 || likedByMeJSON(got) != "false" {
+				t.Errorf("after unliking: like count %d liked_by_me %q, want 0 and false", got.LikeCount, likedByMeJSON(got))
+			}
+		})
+	}
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
-	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,12 +15,17 @@ type UserToken struct {
 	// the key will be the name of struct field unless you give it an explicit JSON tag
 	ID           int    `json:"id"`
 	Email        string `json:"email"`
+	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	Role         string `json:"role"`
+	Verified     bool   `json:"verified"`
 	Token        string `json:"token"`
 	RefreshToken string `json:"refresh_token"`
 }
 
 func (cfg *apiConfig) postLoginHandler(w http.ResponseWriter, req *http.Request) {
 
+	logger := requestLogger(req)
+
 	type parameters struct {
 		// these tags indicate how the keys in the JSON should be mapped to the struct fields
 		// the struct fields must be exported (start with a capital letter) if you want them parsed
@@ -34,63 +39,82 @@ func (cfg *apiConfig) postLoginHandler(w http.ResponseWriter, req *http.Request)
 	if err != nil {
 		// an error will be thrown if the JSON is invalid or has the wrong types
 		// any missing fields will simply have their values in the struct set to their zero value
-		log.Printf("Error decoding parameters: %s", err)
+		logger.Info("Error decoding parameters", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
 
-	id, exists := cfg.chirpyDatabase.UserIDLookup(params.Email)
+	user, err := cfg.chirpyDatabase.GetUserByEmail(params.Email)
 
-	if !exists {
-		log.Printf("Email entered, %s, does not match a registered email", params.Email)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Login for unregistered email", "email", params.Email)
 		respondWithError(w, http.StatusBadRequest, "Email entered does not match a registered email")
 		return
 	}
 
-	user := cfg.chirpyDatabase.Data.Users[id]
+	if err != nil {
+		logger.Error("Failed to look up user", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user")
+		return
+	}
 
 	passwordCheckErr := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(params.Password))
 
 	if passwordCheckErr != nil {
-		log.Printf("Password entered does not match stored password for this email address: %s", params.Email)
+		logger.Warn("Login with wrong password", "user_id", user.ID)
 		respondWithError(w, http.StatusUnauthorized, "Password entered does not match stored password for this email address")
 		return
 	}
 
 	timeNow := time.Now().UTC()
 
-	access_Claims := &jwt.RegisteredClaims{
-		Issuer:    "chirpy-access",
-		IssuedAt:  jwt.NewNumericDate(timeNow),
-		ExpiresAt: jwt.NewNumericDate(timeNow.Add(time.Hour)),
-		Subject:   strconv.Itoa(user.ID),
+	enrollment, err := cfg.chirpyDatabase.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		logger.Error("Failed to look up two-factor authentication", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
+		return
 	}
+	if err == nil && enrollment.Enabled() {
+		cfg.respondWithMFAChallenge(w, logger, user, timeNow)
+		return
+	}
+
+	cfg.respondWithSession(w, logger, user, timeNow)
+
+}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, access_Claims)
-	signedAccessToken, err := accessToken.SignedString([]byte(cfg.jwtSecret))
+// respondWithSession starts a session for a user who has proved who they are,
+// responding with their profile, an access token and a refresh token
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, logger *slog.Logger, user database.User, timeNow time.Time) {
+	signedAccessToken, err := cfg.makeAccessToken(user.ID, timeNow)
 	if err != nil {
-		log.Printf("Error generating a signed string of the JWT with error: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		logger.Error("Failed to sign access token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token")
 		return
 	}
 
-	refreshExpiration := time.Duration(time.Hour * 24 * 60)
-
-	refreshClaims := &jwt.RegisteredClaims{
-		Issuer:    "chirpy-refresh",
-		IssuedAt:  jwt.NewNumericDate(timeNow),
-		ExpiresAt: jwt.NewNumericDate(timeNow.Add(refreshExpiration)),
-		Subject:   strconv.Itoa(user.ID),
+	// each login starts a new family of rotated refresh tokens
+	familyID, err := randomHex(16)
+	if err != nil {
+		logger.Error("Failed to generate refresh token family", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+		return
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	signedRefreshToken, err := refreshToken.SignedString([]byte(cfg.jwtSecret))
+	refreshToken, refreshRecord, err := cfg.newRefreshToken(user.ID, familyID, timeNow)
 	if err != nil {
-		log.Printf("Error generating a signed string of the JWT with error: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		logger.Error("Failed to generate refresh token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, UserToken{ID: user.ID, Email: user.Email, Token: signedAccessToken, RefreshToken: signedRefreshToken})
+	err = cfg.chirpyDatabase.CreateRefreshToken(refreshRecord)
+	if err != nil {
+		logger.Error("Failed to store refresh token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+		return
+	}
 
+	profile := userResponse(user)
+	respondWithJSON(w, http.StatusOK, UserToken{ID: profile.ID, Email: profile.Email, IsChirpyRed: profile.IsChirpyRed, Role: profile.Role, Verified: profile.Verified, Token: signedAccessToken, RefreshToken: refreshToken})
 }
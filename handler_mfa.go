@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/totp"
+)
+
+// MFAChallenge is returned by a password login instead of tokens when the user has two-factor authentication on
+type MFAChallenge struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// respondWithMFAChallenge answers a correct password with a challenge token to exchange,
+// together with a second factor code, at POST /api/login/mfa
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, logger *slog.Logger, user database.User, timeNow time.Time) {
+	token, record, err := cfg.newOneTimeToken(purposeLoginMFA, user, mfaChallengeTTL, timeNow)
+	if err != nil {
+		logger.Error("Failed to create two-factor challenge", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
+		return
+	}
+
+	err = cfg.chirpyDatabase.CreateOneTimeToken(record)
+	if err != nil {
+		logger.Error("Failed to store two-factor challenge", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
+		return
+	}
+
+	logger.Info("Password accepted, waiting for second factor", "user_id", user.ID)
+	respondWithJSON(w, http.StatusOK, MFAChallenge{MFARequired: true, MFAToken: token, ExpiresAt: record.ExpiresAt})
+}
+
+// postLoginMFAHandler finishes a login started by postLoginHandler.
+// The challenge can be retried until it expires or a code is accepted; wrong codes count towards a lockout.
+func (cfg *apiConfig) postLoginMFAHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	type parameters struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		logger.Info("Error decoding parameters", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	timeNow := time.Now().UTC()
+
+	claims, err := cfg.parseOneTimeToken(purposeLoginMFA, params.MFAToken)
+	if err != nil {
+		problem := oneTimeTokenProblem(err)
+		if problem == "" {
+			problem = "is invalid"
+		}
+		logger.Info("Refused two-factor challenge", "err", err)
+		respondWithError(w, http.StatusUnauthorized, "This login attempt "+problem+", log in again")
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		logger.Warn("Two-factor challenge has an invalid subject", "err", err)
+		respondWithError(w, http.StatusUnauthorized, "This login attempt is invalid, log in again")
+		return
+	}
+
+	enrollment, err := cfg.chirpyDatabase.GetTOTP(userID)
+	if errors.Is(err, database.ErrNotExist) || (err == nil && !enrollment.Enabled()) {
+		// turned off since the password step; that login never finished, so start over
+		logger.Info("Two-factor authentication was turned off during login", "user_id", userID)
+		respondWithError(w, http.StatusUnauthorized, "Two-factor authentication is no longer on, log in again")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to look up two-factor authentication", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
+		return
+	}
+
+	usedRecoveryCode, err := cfg.checkSecondFactor(enrollment, params.Code, timeNow)
+	if errors.Is(err, errTOTPLocked) {
+		logger.Warn("Two-factor code refused, too many wrong codes", "user_id", userID)
+		respondWithError(w, http.StatusTooManyRequests, "Too many wrong codes, try again later")
+		return
+	}
+	if errors.Is(err, errWrongCode) {
+		logger.Warn("Wrong two-factor code", "user_id", userID)
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to check two-factor code", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
+		return
+	}
+
+	user, err := cfg.redeemOneTimeToken(purposeLoginMFA, params.MFAToken, timeNow)
+	if problem := oneTimeTokenProblem(err); problem != "" {
+		logger.Info("Refused two-factor challenge", "err", err)
+		respondWithError(w, http.StatusUnauthorized, "This login attempt "+problem+", log in again")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to redeem two-factor challenge", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
+		return
+	}
+
+	if usedRecoveryCode {
+		logger.Warn("Logged in with a recovery code", "user_id", user.ID)
+		cfg.recordAuditEvent(logger, req, user.ID, database.AuditRecoveryCodeUsed, timeNow)
+	}
+
+	cfg.respondWithSession(w, logger, user, timeNow)
+
+}
+
+// postTOTPHandler starts enrolling the user's authenticator app.
+// Two-factor authentication stays off until postTOTPConfirmHandler sees a valid code.
+func (cfg *apiConfig) postTOTPHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	user, err := cfg.chirpyDatabase.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Authenticated user no longer exists")
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to look up user", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set up two-factor authentication")
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		logger.Error("Failed to generate totp secret", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set up two-factor authentication")
+		return
+	}
+
+	sealed, err := cfg.sealTOTPSecret(userID, secret)
+	if err != nil {
+		logger.Error("Failed to encrypt totp secret", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set up two-factor authentication")
+		return
+	}
+
+	err = cfg.chirpyDatabase.StartTOTPEnrollment(userID, sealed)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		logger.Info("Two-factor authentication is already on")
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already on")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to store totp enrollment", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set up two-factor authentication")
+		return
+	}
+
+	type response struct {
+		// Secret is for typing into an authenticator app by hand
+		Secret string `json:"secret"`
+		// ProvisioningURI is the otpauth:// URI to show as a QR code
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+	respondWithJSON(w, http.StatusCreated, response{
+		Secret:          totp.Encode(secret),
+		ProvisioningURI: totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	})
+
+}
+
+// postTOTPConfirmHandler turns on two-factor authentication once the user proves their app has the secret,
+// and returns their recovery codes. The codes are only ever shown here.
+func (cfg *apiConfig) postTOTPConfirmHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		logger.Info("Error decoding parameters", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	enrollment, err := cfg.chirpyDatabase.GetTOTP(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Confirmed totp without starting enrollment")
+		respondWithError(w, http.StatusNotFound, "Start setting up two-factor authentication first")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to look up totp enrollment", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn on two-factor authentication")
+		return
+	}
+	if enrollment.Enabled() {
+		logger.Info("Two-factor authentication is already on")
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already on")
+		return
+	}
+
+	secret, err := cfg.openTOTPSecret(userID, enrollment.Secret)
+	if err != nil {
+		logger.Error("Failed to decrypt totp secret", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn on two-factor authentication")
+		return
+	}
+
+	timeNow := time.Now().UTC()
+
+	step, ok := totp.Validate(secret, normalizeCode(params.Code), timeNow)
+	if !ok {
+		logger.Info("Wrong code confirming totp enrollment")
+		respondWithError(w, http.StatusBadRequest, "Invalid code, check your authenticator app's clock")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		logger.Error("Failed to generate recovery codes", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn on two-factor authentication")
+		return
+	}
+
+	err = cfg.chirpyDatabase.EnableTOTP(userID, step, hashes, timeNow)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		logger.Info("Two-factor authentication is already on")
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already on")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to enable totp", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn on two-factor authentication")
+		return
+	}
+
+	logger.Info("Turned on two-factor authentication")
+	cfg.recordAuditEvent(logger, req, userID, database.AuditTOTPEnabled, timeNow)
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+
+}
+
+// deleteTOTPHandler turns off two-factor authentication.
+// Once it is on, a current code or recovery code is needed, so a stolen access token alone can't turn it off.
+func (cfg *apiConfig) deleteTOTPHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		logger.Info("Error decoding parameters", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	enrollment, err := cfg.chirpyDatabase.GetTOTP(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Two-factor authentication is not on")
+		respondWithError(w, http.StatusNotFound, "Two-factor authentication is not on")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to look up totp enrollment", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn off two-factor authentication")
+		return
+	}
+
+	timeNow := time.Now().UTC()
+
+	if enrollment.Enabled() {
+		_, err = cfg.checkSecondFactor(enrollment, params.Code, timeNow)
+		if errors.Is(err, errTOTPLocked) {
+			logger.Warn("Two-factor code refused, too many wrong codes")
+			respondWithError(w, http.StatusTooManyRequests, "Too many wrong codes, try again later")
+			return
+		}
+		if errors.Is(err, errWrongCode) {
+			logger.Warn("Wrong two-factor code turning off totp")
+			respondWithError(w, http.StatusForbidden, "Invalid code")
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to check two-factor code", "err", err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't turn off two-factor authentication")
+			return
+		}
+	}
+
+	err = cfg.chirpyDatabase.DeleteTOTP(userID)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		logger.Error("Failed to delete totp enrollment", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn off two-factor authentication")
+		return
+	}
+
+	if enrollment.Enabled() {
+		logger.Info("Turned off two-factor authentication")
+		cfg.recordAuditEvent(logger, req, userID, database.AuditTOTPDisabled, timeNow)
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mfaUser is a test user who has turned on two-factor authentication
+type mfaUser struct {
+	id            int
+	email         string
+	secret        []byte
+	enrolledStep  int64
+	recoveryCodes []string
+}
+
+const mfaTestPassword = "correct horse"
+
+// callHandler runs handler on a request with body as JSON, authenticated as userID unless it is 0
+func callHandler(t *testing.T, handler http.HandlerFunc, method, path string, userID int, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(string(data)))
+	if userID != 0 {
+		req = req.WithContext(context.WithValue(req.Context(), contextKeyUserID, userID))
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// enrollMFA creates a user and turns on two-factor authentication through the handlers
+func enrollMFA(t *testing.T, cfg *apiConfig) mfaUser {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(mfaTestPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.chirpyDatabase.CreateUser("mfa@example.com", string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := callHandler(t, cfg.postTOTPHandler, http.MethodPost, "/api/users/totp", user.ID, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("start enrollment: status %d: %s", w.Code, w.Body)
+	}
+	enrollment := struct {
+		Secret string `json:"secret"`
+	}{}
+	err = json.NewDecoder(w.Body).Decode(&enrollment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step := totp.Step(time.Now())
+	w = callHandler(t, cfg.postTOTPConfirmHandler, http.MethodPost, "/api/users/totp/confirm", user.ID, map[string]string{"code": totp.Code(secret, step)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm enrollment: status %d: %s", w.Code, w.Body)
+	}
+	confirmed := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{}
+	err = json.NewDecoder(w.Body).Decode(&confirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mfaUser{id: user.ID, email: user.Email, secret: secret, enrolledStep: step, recoveryCodes: confirmed.RecoveryCodes}
+}
+
+// startLogin passes the password step and returns the two-factor challenge token
+func startLogin(t *testing.T, cfg *apiConfig, user mfaUser) string {
+	t.Helper()
+	w := callHandler(t, cfg.postLoginHandler, http.MethodPost, "/api/login", 0, map[string]string{"email": user.email, "password": mfaTestPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body)
+	}
+	challenge := MFAChallenge{}
+	err := json.NewDecoder(w.Body).Decode(&challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("login did not ask for a second factor: %+v", challenge)
+	}
+	return challenge.MFAToken
+}
+
+// finishLogin answers a two-factor challenge with code and returns the status
+func finishLogin(t *testing.T, cfg *apiConfig, mfaToken, code string) int {
+	t.Helper()
+	w := callHandler(t, cfg.postLoginMFAHandler, http.MethodPost, "/api/login/mfa", 0, map[string]string{"mfa_token": mfaToken, "code": code})
+	return w.Code
+}
+
+// wrongCode returns a six digit code that secret doesn't produce anywhere near now
+func wrongCode(secret []byte) string {
+	current := totp.Step(time.Now())
+	valid := map[string]bool{}
+	for step := current - 3; step <= current+3; step++ {
+		valid[totp.Code(secret, step)] = true
+	}
+	for _, code := range []string{"000000", "111111", "222222", "333333", "444444", "555555", "666666", "777777", "888888"} {
+		if !valid[code] {
+			return code
+		}
+	}
+	return "999999"
+}
+
+func TestLoginMFARejectsReusedStep(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			user := enrollMFA(t, cfg)
+
+			// the code that turned two-factor authentication on is used up
+			if status := finishLogin(t, cfg, startLogin(t, cfg, user), totp.Code(user.secret, user.enrolledStep)); status != http.StatusUnauthorized {
+				t.Errorf("login with the enrollment code: status %d, want %d", status, http.StatusUnauthorized)
+			}
+
+			// the next step is within the allowed skew, so it logs in once
+			next := totp.Code(user.secret, user.enrolledStep+1)
+			if status := finishLogin(t, cfg, startLogin(t, cfg, user), next); status != http.StatusOK {
+				t.Fatalf("login with an unused code: status %d, want %d", status, http.StatusOK)
+			}
+			if status := finishLogin(t, cfg, startLogin(t, cfg, user), next); status != http.StatusUnauthorized {
+				t.Errorf("login with the same code again: status %d, want %d", status, http.StatusUnauthorized)
+			}
+
+			// a recovery code also works only once
+			if status := finishLogin(t, cfg, startLogin(t, cfg, user), user.recoveryCodes[0]); status != http.StatusOK {
+				t.Fatalf("login with a recovery code: status %d, want %d", status, http.StatusOK)
+			}
+			if status := finishLogin(t, cfg, startLogin(t, cfg, user), user.recoveryCodes[0]); status != http.StatusUnauthorized {
+				t.Errorf("login with a used recovery code: status %d, want %d", status, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestLoginMFALockout(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			user := enrollMFA(t, cfg)
+			mfaToken := startLogin(t, cfg, user)
+
+			wrong := wrongCode(user.secret)
+			for i := 0; i < maxTOTPFailures; i++ {
+				if status := finishLogin(t, cfg, mfaToken, wrong); status != http.StatusUnauthorized {
+					t.Fatalf("wrong code %d: status %d, want %d", i+1, status, http.StatusUnauthorized)
+				}
+			}
+
+			// once locked, right codes are refused too, so guessing can't carry on
+			valid := totp.Code(user.secret, user.enrolledStep+1)
+			if status := finishLogin(t, cfg, mfaToken, valid); status != http.StatusTooManyRequests {
+				t.Errorf("valid code after %d wrong ones: status %d, want %d", maxTOTPFailures, status, http.StatusTooManyRequests)
+			}
+			if status := finishLogin(t, cfg, startLogin(t, cfg, user), user.recoveryCodes[0]); status != http.StatusTooManyRequests {
+				t.Errorf("recovery code while locked: status %d, want %d", status, http.StatusTooManyRequests)
+			}
+
+			// the lockout runs from the last wrong code
+			enrollment, err := cfg.chirpyDatabase.GetTOTP(user.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			later := enrollment.LastFailedAt.Add(totpLockout)
+			_, err = cfg.checkSecondFactor(enrollment, totp.Code(user.secret, totp.Step(later)), later)
+			if err != nil {
+				t.Errorf("valid code once the lockout has passed: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoginMFALockoutParallel(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			user := enrollMFA(t, cfg)
+			mfaToken := startLogin(t, cfg, user)
+
+			// guesses sent at once must not all be checked against the same count of earlier failures
+			const guesses = 4 * maxTOTPFailures
+			wrong := wrongCode(user.secret)
+			statuses := make(chan int, guesses)
+			var wg sync.WaitGroup
+			for range guesses {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					statuses <- finishLogin(t, cfg, mfaToken, wrong)
+				}()
+			}
+			wg.Wait()
+			close(statuses)
+
+			counts := map[int]int{}
+			for status := range statuses {
+				counts[status]++
+			}
+			if counts[http.StatusUnauthorized] != maxTOTPFailures || counts[http.StatusTooManyRequests] != guesses-maxTOTPFailures {
+				t.Errorf("%d parallel wrong codes got statuses %v, want %d refused as wrong and the rest locked out",
+					guesses, counts, maxTOTPFailures)
+			}
+		})
+	}
+}
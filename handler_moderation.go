@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func (cfg *apiConfig) moderationReloadHandler(w http.ResponseWriter, req *http.Request) {
+	logger := requestLogger(req)
+
+	err := cfg.moderator.Reload()
+	if err != nil {
+		logger.Error("Failed to reload moderation rules", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reload moderation rules, the previous rules are still in effect")
+		return
+	}
+
+	logger.Info("Reloaded moderation rules")
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte("Moderation rules have been reloaded"))
+}
+
+func (cfg *apiConfig) getFlaggedChirpsHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	query, err := parseChirpQuery(req)
+	if err != nil {
+		logger.Info("Invalid flagged chirps query", "err", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.FlaggedOnly = true
+
+	chirps, err := cfg.chirpyDatabase.GetChirps(peekQuery(query))
+	if err != nil {
+		logger.Error("Failed to get flagged chirps", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get flagged chirps")
+		return
+	}
+
+	response, err := cfg.renderChirps(req, paginate(w, req, query.Limit, chirps, chirpCursor))
+	if err != nil {
+		logger.Error("Failed to render flagged chirps", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get flagged chirps")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+
+}
+
+func (cfg *apiConfig) approveFlaggedChirpHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	id, err := strconv.Atoi(chi.URLParam(req, "chirpID"))
+	if err != nil {
+		logger.Info("Invalid chirp ID in request", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't get chirp ID")
+		return
+	}
+
+	chirp, err := cfg.chirpyDatabase.SetChirpFlagged(id, false)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Chirp does not exist", "chirp_id", id)
+		respondWithError(w, http.StatusNotFound, "Chirp ID requested does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to approve chirp", "chirp_id", id, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't approve chirp")
+		return
+	}
+
+	response, err := cfg.renderChirps(req, []database.Chirp{chirp})
+	if err != nil {
+		logger.Error("Failed to render approved chirp", "chirp_id", id, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't approve chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response[0])
+
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/mailer"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// purposePasswordReset is the one-time token purpose of password reset tokens
+const purposePasswordReset = "chirpy-password-reset"
+
+// passwordResetTTL is how long a password reset token works
+const passwordResetTTL = time.Hour
+
+// postPasswordResetRequestHandler emails a reset token to a registered address.
+// It answers 202 whether or not the address is registered, and does the work
+// after answering so not even the response time gives that away.
+func (cfg *apiConfig) postPasswordResetRequestHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		logger.Info("Error decoding parameters", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	email, ok := validateEmail(params.Email)
+	if !ok {
+		logger.Info("Invalid email address")
+		respondWithError(w, http.StatusBadRequest, "Enter a valid email address")
+		return
+	}
+
+	cfg.goBackground(func() {
+		err := cfg.sendPasswordResetEmail(logger, email)
+		if err != nil {
+			logger.Error("Failed to send password reset email", "err", err)
+		}
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+
+}
+
+// sendPasswordResetEmail emails a reset token to the user registered with email, if there is one
+func (cfg *apiConfig) sendPasswordResetEmail(logger *slog.Logger, email string) error {
+	user, err := cfg.chirpyDatabase.GetUserByEmail(email)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Password reset requested for an unregistered email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, record, err := cfg.newOneTimeToken(purposePasswordReset, user, passwordResetTTL, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	err = cfg.chirpyDatabase.CreateOneTimeToken(record)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Sending password reset email", "user_id", user.ID)
+	return cfg.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"To choose a new password, send this token with it to %s/api/password-reset/confirm:\n\n%s\n\n"+
+			"The token works once and expires in %s. If you didn't ask for this, ignore this email and your password stays the same.\n",
+			cfg.publicURL, token, passwordResetTTL),
+	})
+}
+
+// postPasswordResetConfirmHandler sets a new password using a token from sendPasswordResetEmail.
+// Every session the user had is signed out.
+func (cfg *apiConfig) postPasswordResetConfirmHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		logger.Info("Error decoding parameters", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if params.Password == "" {
+		logger.Info("User did not enter a password")
+		respondWithError(w, http.StatusBadRequest, "Enter a password")
+		return
+	}
+
+	// hash first so a bcrypt failure doesn't use up the token
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Error("Failed to hash password", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not hash password")
+		return
+	}
+
+	timeNow := time.Now().UTC()
+
+	user, err := cfg.redeemOneTimeToken(purposePasswordReset, params.Token, timeNow)
+	if problem := oneTimeTokenProblem(err); problem != "" {
+		logger.Info("Refused password reset token", "err", err)
+		respondWithError(w, http.StatusBadRequest, "This reset token "+problem)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to redeem password reset token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+
+	_, err = cfg.chirpyDatabase.UpdateUser(user.ID, user.Email, string(hashedPassword))
+	if err != nil {
+		logger.Error("Failed to update password", "user_id", user.ID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+
+	// whoever knew the old password may still be signed in
+	err = cfg.chirpyDatabase.RevokeUserRefreshTokens(user.ID, timeNow)
+	if err != nil {
+		logger.Error("Failed to revoke refresh tokens after password reset", "user_id", user.ID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Password was reset but existing sessions could not be signed out")
+		return
+	}
+
+	err = cfg.chirpyDatabase.DiscardOneTimeTokens(user.ID, purposePasswordReset, timeNow)
+	if err != nil {
+		logger.Error("Failed to discard other password reset tokens", "user_id", user.ID, "err", err)
+	}
+
+	cfg.recordAuditEvent(logger, req, user.ID, database.AuditPasswordReset, timeNow)
+
+	logger.Info("Password reset", "user_id", user.ID)
+	w.WriteHeader(http.StatusNoContent)
+
+}
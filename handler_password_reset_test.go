@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// auditRecorder is a store that also keeps the audit events written to it
+type auditRecorder struct {
+	database.Store
+	mu     sync.Mutex
+	events []database.AuditEvent
+}
+
+func (s *auditRecorder) CreateAuditEvent(event database.AuditEvent) (database.AuditEvent, error) {
+	event, err := s.Store.CreateAuditEvent(event)
+	if err == nil {
+		s.mu.Lock()
+		s.events = append(s.events, event)
+		s.mu.Unlock()
+	}
+	return event, err
+}
+
+// requestPasswordReset asks for a reset email for email and waits for it to be sent
+func requestPasswordReset(t *testing.T, cfg *apiConfig, email string) {
+	t.Helper()
+	w := callHandler(t, cfg.postPasswordResetRequestHandler, http.MethodPost, "/api/password-reset/request", 0, map[string]string{"email": email})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("request reset for %s: status %d, want %d", email, w.Code, http.StatusAccepted)
+	}
+	cfg.background.Wait()
+}
+
+// confirmPasswordReset sends token with a new password
+func confirmPasswordReset(t *testing.T, cfg *apiConfig, token, password string) int {
+	t.Helper()
+	return callHandler(t, cfg.postPasswordResetConfirmHandler, http.MethodPost, "/api/password-reset/confirm", 0,
+		map[string]string{"token": token, "password": password}).Code
+}
+
+func TestPasswordReset(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			mail := &recordingMailer{}
+			cfg.mailer = mail
+			store := &auditRecorder{Store: cfg.chirpyDatabase}
+			cfg.chirpyDatabase = store
+
+			user, err := cfg.chirpyDatabase.CreateUser("reset@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessions := []string{
+				startSession(t, cfg, user.ID, time.Now().UTC()),
+				startSession(t, cfg, user.ID, time.Now().UTC()),
+			}
+
+			// unregistered addresses get the same answer and no email
+			requestPasswordReset(t, cfg, "nobody@example.com")
+			if len(mail.messages) != 0 {
+				t.Fatalf("reset for an unregistered email sent %d messages", len(mail.messages))
+			}
+
+			requestPasswordReset(t, cfg, "Reset@Example.com")
+			earlier := mail.lastToken(t, "reset@example.com")
+			requestPasswordReset(t, cfg, "reset@example.com")
+			token := mail.lastToken(t, "reset@example.com")
+
+			// a verification token for the same user is for another purpose
+			verifyToken, record, err := cfg.newOneTimeToken(purposeVerifyEmail, user, verificationTTL, time.Now().UTC())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.chirpyDatabase.CreateOneTimeToken(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, refused := range map[string]string{"garbage": "not-a-token", "wrong purpose": verifyToken} {
+				if status := confirmPasswordReset(t, cfg, refused, "new password"); status != http.StatusBadRequest {
+					t.Errorf("%s token: status %d, want %d", name, status, http.StatusBadRequest)
+				}
+			}
+			// refused before the token is redeemed, so it can still be used
+			if status := confirmPasswordReset(t, cfg, token, ""); status != http.StatusBadRequest {
+				t.Errorf("empty password: status %d, want %d", status, http.StatusBadRequest)
+			}
+
+			if status := confirmPasswordReset(t, cfg, token, "new password"); status != http.StatusNoContent {
+				t.Fatalf("valid token: status %d, want %d", status, http.StatusNoContent)
+			}
+
+			stored, err := cfg.chirpyDatabase.GetUser(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bcrypt.CompareHashAndPassword([]byte(stored.HashedPassword), []byte("new password")) != nil {
+				t.Error("password was not changed")
+			}
+			for i, session := range sessions {
+				if w := callWithBearer(cfg.postRefreshHandler, "/api/refresh", session); w.Code != http.StatusUnauthorized {
+					t.Errorf("session %d after the reset: status %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+				}
+			}
+			if len(store.events) != 1 || store.events[0].UserID != user.ID || store.events[0].Type != database.AuditPasswordReset {
+				t.Errorf("audit events %+v, want one %s event for user %d", store.events, database.AuditPasswordReset, user.ID)
+			}
+
+			if status := confirmPasswordReset(t, cfg, token, "another password"); status != http.StatusBadRequest {
+				t.Errorf("replayed token: status %d, want %d", status, http.StatusBadRequest)
+			}
+			// a reset discards every other reset token of the user
+			if status := confirmPasswordReset(t, cfg, earlier, "another password"); status != http.StatusBadRequest {
+				t.Errorf("earlier token after the reset: status %d, want %d", status, http.StatusBadRequest)
+			}
+			// but not tokens for other purposes
+			if status := verify(t, cfg, verifyToken); status != http.StatusOK {
+				t.Errorf("verification token after the reset: status %d, want %d", status, http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+func (cfg *apiConfig) postPolkaWebhookHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	apiKey, found := strings.CutPrefix(req.Header.Get("Authorization"), "ApiKey ")
+	if !found || cfg.polkaKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.polkaKey)) != 1 {
+		logger.Warn("Polka webhook called without a valid API key")
+		respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+
+	type parameters struct {
+		// these tags indicate how the keys in the JSON should be mapped to the struct fields
+		// the struct fields must be exported (start with a capital letter) if you want them parsed
+		Event string `json:"event"`
+		Data  struct {
+			UserID int `json:"user_id"`
+		} `json:"data"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		logger.Info("Error decoding parameters", "err", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	if params.Event != "user.upgraded" {
+		// acknowledge events we don't care about so Polka stops retrying them
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_, err = cfg.chirpyDatabase.SetChirpyRed(params.Data.UserID, true)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Polka webhook for a user that does not exist", "user_id", params.Data.UserID)
+		respondWithError(w, http.StatusNotFound, "User does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to upgrade user", "user_id", params.Data.UserID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upgrade user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// postRechirpHandler re-shares {chirpID} as a chirp by the authenticated user with no body of its own.
+// Rechirping a rechirp re-shares its original. To quote a chirp, post a chirp with quote_of_id instead.
+func (cfg *apiConfig) postRechirpHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(req, "chirpID"))
+	if err != nil {
+		logger.Info("Invalid chirp ID", "chirp_id", chi.URLParam(req, "chirpID"))
+		respondWithError(w, http.StatusBadRequest, "Chirp ID must be a number")
+		return
+	}
+
+	rechirp, err := cfg.chirpyDatabase.CreateChirp(database.Chirp{
+		AuthorID:    userID,
+		RechirpOfID: chirpID,
+	})
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Chirp to rechirp does not exist", "chirp_id", chirpID)
+		respondWithError(w, http.StatusNotFound, "Chirp ID requested does not exist")
+		return
+	}
+	if errors.Is(err, database.ErrAlreadyExists) {
+		logger.Info("User already rechirped chirp", "chirp_id", chirpID)
+		respondWithError(w, http.StatusConflict, "You've already rechirped this chirp")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to create rechirp", "chirp_id", chirpID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rechirp chirp")
+		return
+	}
+
+	cfg.respondWithNewChirp(w, req, rechirp)
+
+}
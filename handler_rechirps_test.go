@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+// getChirp fetches chirp id through the handler as userID
+func getChirp(t *testing.T, cfg *apiConfig, id, userID int) Chirp {
+	t.Helper()
+	w := callRoute(t, "/api/chirps/{chirpID}", cfg.getChirpHandler, http.MethodGet, fmt.Sprintf("/api/chirps/%d", id), userID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get chirp %d: status %d: %s", id, w.Code, w.Body)
+	}
+	chirp := Chirp{}
+	err := json.NewDecoder(w.Body).Decode(&chirp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chirp
+}
+
+func TestRechirpsAndQuotesEmbedOriginal(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			author, err := cfg.chirpyDatabase.CreateUser("author@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fan, err := cfg.chirpyDatabase.CreateUser("fan@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			original, err := cfg.chirpyDatabase.CreateChirp(database.Chirp{Body: "original", AuthorID: author.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.chirpyDatabase.LikeChirp(fan.ID, original.ID, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rechirpPath := fmt.Sprintf("/api/chirps/%d/rechirps", original.ID)
+			w := callRoute(t, "/api/chirps/{chirpID}/rechirps", cfg.postRechirpHandler, http.MethodPost, rechirpPath, fan.ID, nil)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("rechirp: status %d: %s", w.Code, w.Body)
+			}
+			rechirp := Chirp{}
+			err = json.NewDecoder(w.Body).Decode(&rechirp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w = callRoute(t, "/api/chirps/{chirpID}/rechirps", cfg.postRechirpHandler, http.MethodPost, rechirpPath, fan.ID, nil)
+			if w.Code != http.StatusConflict {
+				t.Errorf("second rechirp: status %d, want %d", w.Code, http.StatusConflict)
+			}
+
+			w = callHandler(t, cfg.postChirpHandler, http.MethodPost, "/api/chirps", fan.ID, map[string]any{"body": "so true", "quote_of_id": rechirp.ID})
+			if w.Code != http.StatusCreated {
+				t.Fatalf("quote: status %d: %s", w.Code, w.Body)
+			}
+			quote := Chirp{}
+			err = json.NewDecoder(w.Body).Decode(&quote)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range []int{rechirp.ID, quote.ID} {
+				got := getChirp(t, cfg, id, fan.ID)
+				if got.Original == nil || got.Original.ID != original.ID || got.Original.Body != "original" || got.Original.AuthorID != author.ID {
+					t.Fatalf("chirp %d embeds %+v, want the original", id, got.Original)
+				}
+				if got.Original.LikedByMe == nil || !*got.Original.LikedByMe || got.Original.LikeCount != 1 {
+					t.Errorf("chirp %d embeds an original with like_count %d and liked_by_me %v, want 1 and true",
+						id, got.Original.LikeCount, got.Original.LikedByMe)
+				}
+			}
+			if got := getChirp(t, cfg, quote.ID, 0); got.Original == nil || got.Original.LikedByMe != nil {
+				t.Errorf("anonymous read embeds %+v, want the original without liked_by_me", got.Original)
+			}
+
+			// once the original goes the quote stays, still pointing at it, but embeds nothing
+			err = cfg.chirpyDatabase.DeleteChirp(original.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := getChirp(t, cfg, quote.ID, fan.ID)
+			if got.QuoteOfID != original.ID || got.Original != nil {
+				t.Errorf("quote of a deleted chirp has quote_of_id %d and original %+v, want %d and none", got.QuoteOfID, got.Original, original.ID)
+			}
+		})
+	}
+}
@@ -1,89 +1,121 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
-	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
 )
 
 type RefreshToken struct {
 	// the key will be the name of struct field unless you give it an explicit JSON tag
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 func (cfg *apiConfig) postRefreshHandler(w http.ResponseWriter, req *http.Request) {
 
-	header := req.Header.Get("Authorization")
+	logger := requestLogger(req)
 
-	tokenString := strings.TrimPrefix(header, "Bearer ")
+	tokenString, found := getBearerToken(req)
+	if !found {
+		logger.Info("Refresh request is missing a bearer token")
+		respondWithError(w, http.StatusUnauthorized, "Missing bearer token")
+		return
+	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
+	timeNow := time.Now().UTC()
 
-	if err != nil {
-		log.Printf("Error parsing token: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Bad Token")
+	oldHash := hashToken(tokenString)
+	oldToken, err := cfg.chirpyDatabase.GetRefreshToken(oldHash)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Refresh token does not exist")
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
 		return
 	}
-
-	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		log.Printf("Could not extract issuer from token claims: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Could not extract issuer from token")
+		logger.Error("Failed to look up refresh token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check refresh token")
 		return
 	}
 
-	if issuer != "chirpy-refresh" {
-		log.Printf("Invalid token issuer: %s", issuer)
-		respondWithError(w, http.StatusUnauthorized, "Invalid token issuer")
+	if oldToken.Revoked() {
+		cfg.revokeRefreshTokenFamily(logger, oldToken, timeNow)
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token. This token has been revoked.")
 		return
 	}
 
-	if _, exists := cfg.revokedTokens[tokenString]; exists {
-		log.Printf("Refresh token has been revoked and is no longer valid: %s", tokenString)
-		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token. This token has been revoked.")
+	if timeNow.After(oldToken.ExpiresAt) {
+		logger.Info("Refresh token has expired", "user_id", oldToken.UserID)
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired")
 		return
 	}
 
-	idString, err := token.Claims.GetSubject()
+	newToken, newRecord, err := cfg.newRefreshToken(oldToken.UserID, oldToken.FamilyID, timeNow)
 	if err != nil {
-		log.Printf("Could not extract subject from token claims: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Could not extract subject from token")
+		logger.Error("Failed to generate refresh token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
 		return
 	}
 
-	timeNow := time.Now().UTC()
-
-	access_Claims := &jwt.RegisteredClaims{
-		Issuer:    "chirpy-access",
-		IssuedAt:  jwt.NewNumericDate(timeNow),
-		ExpiresAt: jwt.NewNumericDate(timeNow.Add(time.Hour)),
-		Subject:   idString,
+	err = cfg.chirpyDatabase.RotateRefreshToken(oldHash, newRecord, timeNow)
+	if errors.Is(err, database.ErrTokenRevoked) {
+		// another request used this token first
+		cfg.revokeRefreshTokenFamily(logger, oldToken, timeNow)
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token. This token has been revoked.")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to rotate refresh token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token")
+		return
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, access_Claims)
-	signedAccessToken, err := accessToken.SignedString([]byte(cfg.jwtSecret))
+	signedAccessToken, err := cfg.makeAccessToken(oldToken.UserID, timeNow)
 	if err != nil {
-		log.Printf("Error generating a signed string of the JWT with error: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		logger.Error("Failed to sign access token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access token")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, RefreshToken{Token: signedAccessToken})
+	respondWithJSON(w, http.StatusOK, RefreshToken{Token: signedAccessToken, RefreshToken: newToken})
+
+}
 
+// revokeRefreshTokenFamily handles an already used refresh token being presented again.
+// That means the token was stolen, so every token descended from the same login is revoked.
+func (cfg *apiConfig) revokeRefreshTokenFamily(logger *slog.Logger, token database.RefreshToken, now time.Time) {
+	logger.Warn("Refresh token reuse detected, revoking token family", "user_id", token.UserID, "family_id", token.FamilyID)
+	err := cfg.chirpyDatabase.RevokeRefreshTokenFamily(token.FamilyID, now)
+	if err != nil {
+		logger.Error("Failed to revoke refresh token family", "family_id", token.FamilyID, "err", err)
+	}
 }
 
 func (cfg *apiConfig) postRevokeTokenHandler(w http.ResponseWriter, req *http.Request) {
 
-	header := req.Header.Get("Authorization")
+	logger := requestLogger(req)
 
-	tokenString := strings.TrimPrefix(header, "Bearer ")
+	tokenString, found := getBearerToken(req)
+	if !found {
+		logger.Info("Revoke request is missing a bearer token")
+		respondWithError(w, http.StatusUnauthorized, "Missing bearer token")
+		return
+	}
 
-	cfg.revokedTokens[tokenString] = time.Now().UTC()
+	err := cfg.chirpyDatabase.RevokeRefreshToken(hashToken(tokenString), time.Now().UTC())
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Attempted to revoke a refresh token that does not exist")
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to revoke refresh token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token")
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// startSession stores the first refresh token of a new login for userID, issued at issuedAt
+func startSession(t *testing.T, cfg *apiConfig, userID int, issuedAt time.Time) string {
+	t.Helper()
+	familyID, err := randomHex(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, record, err := cfg.newRefreshToken(userID, familyID, issuedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.chirpyDatabase.CreateRefreshToken(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// callWithBearer runs handler on a POST to path with token as the bearer token
+func callWithBearer(handler http.HandlerFunc, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// refreshStep is one request in a refresh token scenario
+type refreshStep struct {
+	// revoke sends the token to /api/revoke instead of /api/refresh
+	revoke bool
+	// token names the token to send; a successful refresh saves the new token under as
+	token string
+	as    string
+	want  int
+}
+
+func TestRefreshTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		// expired starts the session so long ago that its first token has expired
+		expired bool
+		steps   []refreshStep
+	}{
+		{"rotation", false, []refreshStep{
+			{token: "login", as: "first", want: http.StatusOK},
+			{token: "first", as: "second", want: http.StatusOK},
+			{token: "second", as: "third", want: http.StatusOK},
+		}},
+		{"rotated token can't be used again", false, []refreshStep{
+			{token: "login", as: "first", want: http.StatusOK},
+			{token: "login", want: http.StatusUnauthorized},
+		}},
+		{"reuse revokes the whole family", false, []refreshStep{
+			{token: "login", as: "first", want: http.StatusOK},
+			{token: "first", as: "second", want: http.StatusOK},
+			{token: "first", want: http.StatusUnauthorized},
+			{token: "second", want: http.StatusUnauthorized},
+		}},
+		{"reuse of the first token revokes the latest", false, []refreshStep{
+			{token: "login", as: "first", want: http.StatusOK},
+			{token: "first", as: "second", want: http.StatusOK},
+			{token: "login", want: http.StatusUnauthorized},
+			{token: "second", want: http.StatusUnauthorized},
+		}},
+		{"revoke", false, []refreshStep{
+			{revoke: true, token: "login", want: http.StatusOK},
+			{token: "login", want: http.StatusUnauthorized},
+		}},
+		{"revoke twice", false, []refreshStep{
+			{revoke: true, token: "login", want: http.StatusOK},
+			{revoke: true, token: "login", want: http.StatusOK},
+		}},
+		{"revoking a rotated token leaves its successor", false, []refreshStep{
+			{token: "login", as: "first", want: http.StatusOK},
+			{revoke: true, token: "login", want: http.StatusOK},
+			{token: "first", as: "second", want: http.StatusOK},
+		}},
+		{"revoking the latest token", false, []refreshStep{
+			{token: "login", as: "first", want: http.StatusOK},
+			{revoke: true, token: "first", want: http.StatusOK},
+			{token: "first", want: http.StatusUnauthorized},
+		}},
+		{"unknown token", false, []refreshStep{
+			{token: "unknown", want: http.StatusUnauthorized},
+			{revoke: true, token: "unknown", want: http.StatusUnauthorized},
+		}},
+		{"expired", true, []refreshStep{
+			{token: "login", want: http.StatusUnauthorized},
+			{revoke: true, token: "login", want: http.StatusOK},
+		}},
+	}
+
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					cfg := newTestConfig(t, driver)
+					user, err := cfg.chirpyDatabase.CreateUser("refresh@example.com", "hash")
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					issuedAt := time.Now().UTC()
+					if tt.expired {
+						issuedAt = issuedAt.Add(-cfg.refreshTokenTTL - time.Minute)
+					}
+					tokens := map[string]string{
+						"login":   startSession(t, cfg, user.ID, issuedAt),
+						"unknown": "0123456789abcdef",
+					}
+					// another login of the same user, which nothing in the scenario should affect
+					bystander := startSession(t, cfg, user.ID, time.Now().UTC())
+
+					for i, step := range tt.steps {
+						if step.revoke {
+							w := callWithBearer(cfg.postRevokeTokenHandler, "/api/revoke", tokens[step.token])
+							if w.Code != step.want {
+								t.Fatalf("step %d: revoke %s: status %d, want %d: %s", i+1, step.token, w.Code, step.want, w.Body)
+							}
+							continue
+						}
+
+						w := callWithBearer(cfg.postRefreshHandler, "/api/refresh", tokens[step.token])
+						if w.Code != step.want {
+							t.Fatalf("step %d: refresh %s: status %d, want %d: %s", i+1, step.token, w.Code, step.want, w.Body)
+						}
+						if w.Code != http.StatusOK {
+							continue
+						}
+						response := RefreshToken{}
+						err := json.NewDecoder(w.Body).Decode(&response)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if response.Token == "" || response.RefreshToken == "" || response.RefreshToken == tokens[step.token] {
+							t.Fatalf("step %d: refresh %s returned %+v, want a new access and refresh token", i+1, step.token, response)
+						}
+						tokens[step.as] = response.RefreshToken
+					}
+
+					if w := callWithBearer(cfg.postRefreshHandler, "/api/refresh", bystander); w.Code != http.StatusOK {
+						t.Errorf("another login's token: status %d, want %d", w.Code, http.StatusOK)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestRefreshWithoutToken(t *testing.T) {
+	cfg := newTestConfig(t, testDrivers[0])
+	for name, handler := range map[string]http.HandlerFunc{"refresh": cfg.postRefreshHandler, "revoke": cfg.postRevokeTokenHandler} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/api/"+name, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a token: status %d, want %d", name, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
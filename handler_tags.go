@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/hashtag"
+	"github.com/go-chi/chi/v5"
+)
+
+// getTagChirpsHandler lists the chirps with hashtag {tag}. Matching ignores case and
+// Unicode variants, so /api/tags/Café/chirps also finds #CAFÉ. A leading '#' is optional.
+func (cfg *apiConfig) getTagChirpsHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	// chi leaves the parameter escaped when the client escaped more than it had to
+	tag, err := url.PathUnescape(chi.URLParam(req, "tag"))
+	if err == nil {
+		tag = strings.TrimPrefix(strings.TrimPrefix(tag, "#"), "＃")
+	}
+	if err != nil || !hashtag.Valid(tag) {
+		logger.Info("Invalid hashtag", "tag", chi.URLParam(req, "tag"))
+		respondWithError(w, http.StatusBadRequest, "Tag must be a hashtag of letters, digits and underscores")
+		return
+	}
+
+	query, err := parseChirpQuery(req)
+	if err != nil {
+		logger.Info("Invalid tag query", "err", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Tag = hashtag.Normalize(tag)
+
+	chirps, err := cfg.chirpyDatabase.GetChirps(peekQuery(query))
+	if err != nil {
+		logger.Error("Failed to get chirps for tag", "tag", query.Tag, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps")
+		return
+	}
+
+	response, err := cfg.renderChirps(req, paginate(w, req, query.Limit, chirps, chirpCursor))
+	if err != nil {
+		logger.Error("Failed to render chirps", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// Thread is a chirp with the chain of chirps it replies to and a page of the replies below it
+type Thread struct {
+	// Ancestors runs from the root of the conversation down to the chirp's parent
+	Ancestors []Chirp `json:"ancestors"`
+	Chirp     Chirp   `json:"chirp"`
+	// Replies are every descendant of the chirp in ID order; in_reply_to_id links them into a tree
+	Replies []Chirp `json:"replies"`
+}
+
+func (cfg *apiConfig) getChirpThreadHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	id, err := strconv.Atoi(chi.URLParam(req, "chirpID"))
+	if err != nil {
+		logger.Info("Invalid chirp ID", "chirp_id", chi.URLParam(req, "chirpID"))
+		respondWithError(w, http.StatusBadRequest, "Chirp ID must be a number")
+		return
+	}
+
+	query, err := parseChirpQuery(req)
+	if err != nil {
+		logger.Info("Invalid thread query", "err", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// deleted chirps that still have replies are kept as tombstones so the tree stays connected
+	query.DescendantsOf = id
+	query.IncludeDeleted = true
+
+	// a tombstone can still be the middle of a thread, so it is returned here rather than a 404
+	chirp, err := cfg.chirpyDatabase.GetChirp(id)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Chirp does not exist", "chirp_id", id)
+		respondWithError(w, http.StatusNotFound, "Chirp ID requested does not exist")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to get chirp", "chirp_id", id, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread")
+		return
+	}
+
+	ancestors := []database.Chirp{}
+	for parentID := chirp.InReplyToID; parentID != 0; {
+		parent, err := cfg.chirpyDatabase.GetChirp(parentID)
+		if err != nil {
+			logger.Error("Failed to get parent chirp", "chirp_id", parentID, "err", err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get thread")
+			return
+		}
+		ancestors = append(ancestors, parent)
+		parentID = parent.InReplyToID
+	}
+	// walked upwards from the parent, so reverse to start at the root
+	for i, j := 0, len(ancestors)-1; i < j; i, j = i+1, j-1 {
+		ancestors[i], ancestors[j] = ancestors[j], ancestors[i]
+	}
+
+	replies, err := cfg.chirpyDatabase.GetChirps(peekQuery(query))
+	if err != nil {
+		logger.Error("Failed to get replies", "chirp_id", id, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread")
+		return
+	}
+
+	// one lookup of the caller's likes covers the whole thread
+	replies = paginate(w, req, query.Limit, replies, chirpCursor)
+	response, err := cfg.renderChirps(req, append(append(ancestors, chirp), replies...))
+	if err != nil {
+		logger.Error("Failed to render chirps", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Thread{
+		Ancestors: response[:len(ancestors)],
+		Chirp:     response[len(ancestors)],
+		Replies:   response[len(ancestors)+1:],
+	})
+
+}
@@ -2,23 +2,56 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
-	"strconv"
-	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
 	// the key will be the name of struct field unless you give it an explicit JSON tag
-	ID    int    `json:"id"`
-	Email string `json:"email"`
+	ID          int    `json:"id"`
+	Email       string `json:"email"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Role        string `json:"role"`
+	Verified    bool   `json:"verified"`
+}
+
+// userResponse converts a stored user into the form the API returns, leaving out the password hash
+func userResponse(user database.User) User {
+	role := user.Role
+	if role == "" {
+		// stored before roles existed
+		role = database.RoleUser
+	}
+	return User{
+		ID:          user.ID,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+		Role:        string(role),
+		Verified:    user.Verified,
+	}
+}
+
+// UserProfile is what anyone can see about a user; it leaves out their email address
+type UserProfile struct {
+	ID          int  `json:"id"`
+	IsChirpyRed bool `json:"is_chirpy_red"`
+}
+
+func profilesResponse(users []database.User) []UserProfile {
+	response := make([]UserProfile, 0, len(users))
+	for _, user := range users {
+		response = append(response, UserProfile{ID: user.ID, IsChirpyRed: user.IsChirpyRed})
+	}
+	return response
 }
 
 func (cfg *apiConfig) postUserHandler(w http.ResponseWriter, req *http.Request) {
 
+	logger := requestLogger(req)
+
 	type parameters struct {
 		// these tags indicate how the keys in the JSON should be mapped to the struct fields
 		// the struct fields must be exported (start with a capital letter) if you want them parsed
@@ -32,13 +65,20 @@ func (cfg *apiConfig) postUserHandler(w http.ResponseWriter, req *http.Request)
 	if err != nil {
 		// an error will be thrown if the JSON is invalid or has the wrong types
 		// any missing fields will simply have their values in the struct set to their zero value
-		log.Printf("Error decoding parameters: %s", err)
+		logger.Info("Error decoding parameters", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
 
+	email, ok := validateEmail(params.Email)
+	if !ok {
+		logger.Info("Invalid email address")
+		respondWithError(w, http.StatusBadRequest, "Enter a valid email address")
+		return
+	}
+
 	if params.Password == "" {
-		log.Printf("User did not enter a password")
+		logger.Info("User did not enter a password")
 		respondWithError(w, http.StatusBadRequest, "Enter a password")
 		return
 	}
@@ -46,25 +86,39 @@ func (cfg *apiConfig) postUserHandler(w http.ResponseWriter, req *http.Request)
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
 
 	if err != nil {
-		log.Printf("Failed to create new user with error: %s", err)
+		logger.Error("Failed to hash password", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Could not hash password")
 		return
 	}
 
-	newUser, err := cfg.chirpyDatabase.CreateUser(params.Email, string(hashedPassword))
+	newUser, err := cfg.chirpyDatabase.CreateUser(email, string(hashedPassword))
 
-	if err != nil {
-		log.Printf("Failed to create new user with error: %s", err)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		logger.Info("Email already registered", "email", email)
 		respondWithError(w, http.StatusUnauthorized, "Email already registered")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, User{ID: newUser.ID, Email: newUser.Email})
+	if err != nil {
+		logger.Error("Failed to create new user", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create new user")
+		return
+	}
+
+	// the account works without it, so a mail failure doesn't fail the signup; the user can ask for another link
+	err = cfg.sendVerificationEmail(newUser)
+	if err != nil {
+		logger.Error("Failed to send verification email", "new_user_id", newUser.ID, "err", err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, userResponse(newUser))
 
 }
 
 func (cfg *apiConfig) putUserHandler(w http.ResponseWriter, req *http.Request) {
 
+	logger := requestLogger(req)
+
 	type parameters struct {
 		// these tags indicate how the keys in the JSON should be mapped to the struct fields
 		// the struct fields must be exported (start with a capital letter) if you want them parsed
@@ -78,74 +132,71 @@ func (cfg *apiConfig) putUserHandler(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		// an error will be thrown if the JSON is invalid or has the wrong types
 		// any missing fields will simply have their values in the struct set to their zero value
-		log.Printf("Error decoding parameters: %s", err)
+		logger.Info("Error decoding parameters", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
 
-	header := req.Header.Get("Authorization")
-
-	tokenString := strings.TrimPrefix(header, "Bearer ")
-
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
-
-	if err != nil {
-		log.Printf("Error parsing token: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Bad Token")
+	id, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
 		return
 	}
 
-	issuer, err := token.Claims.GetIssuer()
-	if err != nil {
-		log.Printf("Could not extract issuer from token claims: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Could not extract issuer from token")
+	email, ok := validateEmail(params.Email)
+	if !ok {
+		logger.Info("Invalid email address")
+		respondWithError(w, http.StatusBadRequest, "Enter a valid email address")
 		return
 	}
 
-	if issuer != "chirpy-access" {
-		log.Printf("Invalid token issuer: %s", issuer)
-		respondWithError(w, http.StatusUnauthorized, "Invalid token issuer")
+	if params.Password == "" {
+		logger.Info("User did not enter a password")
+		respondWithError(w, http.StatusBadRequest, "Enter a password")
 		return
 	}
 
-	idString, err := token.Claims.GetSubject()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+
 	if err != nil {
-		log.Printf("Could not extract subject from token claims: %s", err)
-		respondWithError(w, http.StatusUnauthorized, "Could not extract subject from token")
+		logger.Error("Failed to hash password", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not hash password")
 		return
 	}
 
-	id, err := strconv.Atoi(idString)
-	if err != nil {
-		log.Printf("Could not convert id string to int: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Could not convert id string to int")
+	current, err := cfg.chirpyDatabase.GetUser(id)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Authenticated user no longer exists")
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists")
 		return
 	}
-
-	if params.Password == "" {
-		log.Printf("User did not enter a password")
-		respondWithError(w, http.StatusBadRequest, "Enter a password")
+	if err != nil {
+		logger.Error("Failed to look up user", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not update user")
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+	newUser, err := cfg.chirpyDatabase.UpdateUser(id, email, string(hashedPassword))
 
-	if err != nil {
-		log.Printf("Failed to generate hashed password with error: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Could not hash password")
+	if errors.Is(err, database.ErrAlreadyExists) {
+		logger.Info("Email already registered", "email", email)
+		respondWithError(w, http.StatusConflict, "Email already registered")
 		return
 	}
 
-	newUser, err := cfg.chirpyDatabase.UpdateUser(id, params.Email, string(hashedPassword))
-
 	if err != nil {
-		log.Printf("Failed to update user with error: %s", err)
+		logger.Error("Failed to update user", "err", err)
 		respondWithError(w, http.StatusInternalServerError, "Could not update user")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, User{ID: newUser.ID, Email: newUser.Email})
+	if newUser.Email != current.Email {
+		err = cfg.sendVerificationEmail(newUser)
+		if err != nil {
+			logger.Error("Failed to send verification email", "err", err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, userResponse(newUser))
 
 }
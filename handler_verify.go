@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+// getVerifyEmailHandler redeems the link emailed by sendVerificationEmail
+func (cfg *apiConfig) getVerifyEmailHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	tokenString := req.URL.Query().Get("token")
+	if tokenString == "" {
+		logger.Info("Verification request is missing a token")
+		respondWithError(w, http.StatusBadRequest, "Missing verification token")
+		return
+	}
+
+	user, err := cfg.redeemOneTimeToken(purposeVerifyEmail, tokenString, time.Now().UTC())
+	if problem := oneTimeTokenProblem(err); problem != "" {
+		logger.Info("Refused verification link", "err", err)
+		respondWithError(w, http.StatusBadRequest, "This verification link "+problem)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to redeem verification token", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email address")
+		return
+	}
+
+	user, err = cfg.chirpyDatabase.SetUserVerified(user.ID, true)
+	if err != nil {
+		logger.Error("Failed to mark user verified", "user_id", user.ID, "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email address")
+		return
+	}
+
+	logger.Info("Verified email address", "user_id", user.ID)
+
+	// configured admins are only promoted once they have proven they own the address
+	if cfg.isAdminEmail(user.Email) && user.Role != database.RoleAdmin {
+		user, err = cfg.chirpyDatabase.SetUserRole(user.ID, database.RoleAdmin)
+		if err != nil {
+			logger.Error("Failed to promote verified user to admin", "user_id", user.ID, "err", err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify email address")
+			return
+		}
+		logger.Info("Promoted configured admin", "user_id", user.ID)
+	}
+
+	respondWithJSON(w, http.StatusOK, userResponse(user))
+
+}
+
+// postResendVerificationHandler emails the authenticated user a new verification link
+func (cfg *apiConfig) postResendVerificationHandler(w http.ResponseWriter, req *http.Request) {
+
+	logger := requestLogger(req)
+
+	userID, ok := userIDFromContext(req.Context())
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't identify user")
+		return
+	}
+
+	user, err := cfg.chirpyDatabase.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		logger.Info("Authenticated user no longer exists")
+		respondWithError(w, http.StatusUnauthorized, "User no longer exists")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to look up user", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user")
+		return
+	}
+
+	if user.Verified {
+		respondWithError(w, http.StatusConflict, "Email address is already verified")
+		return
+	}
+
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		logger.Error("Failed to send verification email", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+// signUp creates a user through the signup handler and returns it as the API does
+func signUp(t *testing.T, cfg *apiConfig, email string) User {
+	t.Helper()
+	w := callHandler(t, cfg.postUserHandler, http.MethodPost, "/api/users", 0, map[string]string{"email": email, "password": "password"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("sign up %s: status %d: %s", email, w.Code, w.Body)
+	}
+	user := User{}
+	err := json.NewDecoder(w.Body).Decode(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// verify follows a verification link carrying token
+func verify(t *testing.T, cfg *apiConfig, token string) int {
+	t.Helper()
+	return callHandler(t, cfg.getVerifyEmailHandler, http.MethodGet, "/api/users/verify?token="+url.QueryEscape(token), 0, nil).Code
+}
+
+func TestVerifyEmail(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			mail := &recordingMailer{}
+			cfg.mailer = mail
+			cfg.adminEmails = []string{"Boss@example.com"}
+
+			user := signUp(t, cfg, " Boss@Example.com ")
+			if user.Email != "boss@example.com" || user.Verified {
+				t.Fatalf("signed up as %q verified %v, want boss@example.com unverified", user.Email, user.Verified)
+			}
+			token := mail.lastToken(t, "boss@example.com")
+
+			// a password reset token for the same user is for another purpose
+			stored, err := cfg.chirpyDatabase.GetUser(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resetToken, record, err := cfg.newOneTimeToken(purposePasswordReset, stored, passwordResetTTL, time.Now().UTC())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.chirpyDatabase.CreateOneTimeToken(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, refused := range map[string]string{"missing": "", "garbage": "not-a-token", "wrong purpose": resetToken} {
+				if status := verify(t, cfg, refused); status != http.StatusBadRequest {
+					t.Errorf("%s token: status %d, want %d", name, status, http.StatusBadRequest)
+				}
+			}
+
+			if status := verify(t, cfg, token); status != http.StatusOK {
+				t.Fatalf("valid token: status %d, want %d", status, http.StatusOK)
+			}
+			stored, err = cfg.chirpyDatabase.GetUser(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stored.Verified {
+				t.Error("user is not verified after following the link")
+			}
+			// verifying a configured admin address promotes its user
+			if stored.Role != database.RoleAdmin {
+				t.Errorf("configured admin has role %q after verifying, want %q", stored.Role, database.RoleAdmin)
+			}
+			if status := verify(t, cfg, token); status != http.StatusBadRequest {
+				t.Errorf("replayed token: status %d, want %d", status, http.StatusBadRequest)
+			}
+			if w := callHandler(t, cfg.postResendVerificationHandler, http.MethodPost, "/api/users/verify/resend", user.ID, nil); w.Code != http.StatusConflict {
+				t.Errorf("resend once verified: status %d, want %d", w.Code, http.StatusConflict)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailAfterChange(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			mail := &recordingMailer{}
+			cfg.mailer = mail
+
+			user := signUp(t, cfg, "old@example.com")
+			oldToken := mail.lastToken(t, "old@example.com")
+
+			// the link was sent to the old address, so it can't verify the new one
+			_, err := cfg.chirpyDatabase.UpdateUser(user.ID, "new@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status := verify(t, cfg, oldToken); status != http.StatusBadRequest {
+				t.Errorf("link for the old address: status %d, want %d", status, http.StatusBadRequest)
+			}
+
+			if w := callHandler(t, cfg.postResendVerificationHandler, http.MethodPost, "/api/users/verify/resend", user.ID, nil); w.Code != http.StatusAccepted {
+				t.Fatalf("resend: status %d, want %d", w.Code, http.StatusAccepted)
+			}
+			if status := verify(t, cfg, mail.lastToken(t, "new@example.com")); status != http.StatusOK {
+				t.Errorf("link for the new address: status %d, want %d", status, http.StatusOK)
+			}
+			stored, err := cfg.chirpyDatabase.GetUser(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stored.Verified || stored.Role == database.RoleAdmin {
+				t.Errorf("after verifying: verified %v role %q, want verified and no promotion", stored.Verified, stored.Role)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRequireVerifiedEmail(t *testing.T) {
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			unverified, err := cfg.chirpyDatabase.CreateUser("unverified@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := cfg.chirpyDatabase.CreateUser("verified@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = cfg.chirpyDatabase.SetUserVerified(verified.ID, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name     string
+				required bool
+				userID   int
+				status   int
+			}{
+				{"not required", false, unverified.ID, http.StatusOK},
+				{"unverified", true, unverified.ID, http.StatusForbidden},
+				{"verified", true, verified.ID, http.StatusOK},
+				{"deleted user", true, verified.ID + 100, http.StatusUnauthorized},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					cfg.requireVerifiedEmail = tt.required
+					handler := cfg.middlewareRequireVerifiedEmail(okHandler)
+					if w := callHandler(t, handler.ServeHTTP, http.MethodPost, "/api/chirps", tt.userID, nil); w.Code != tt.status {
+						t.Errorf("status %d, want %d", w.Code, tt.status)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"log/slog"
+	"time"
+)
+
+// audit event types
+const (
+	AuditPasswordReset    = "password_reset"
+	AuditTOTPEnabled      = "totp_enabled"
+	AuditTOTPDisabled     = "totp_disabled"
+	AuditRecoveryCodeUsed = "recovery_code_used"
+)
+
+// AuditEvent records a security-relevant change to a user's account
+type AuditEvent struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Type       string    `json:"type"`
+	RemoteAddr string    `json:"remote_addr"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateAuditEvent stores an audit event; its ID is assigned by the database
+func (db *DB) CreateAuditEvent(event AuditEvent) (AuditEvent, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	id, seq := db.nextID(collectionAuditEvents)
+	event.ID = id
+	err := db.commit(seq, putRecord(collectionAuditEvents, id, event))
+	if err != nil {
+		slog.Error("Failed to write audit event to database")
+		return AuditEvent{}, err
+	}
+	return event, nil
+}
@@ -1,42 +1,375 @@
 package database
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
 	"sort"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/hashtag"
 )
 
 type Chirp struct {
 	// the key will be the name of struct field unless you give it an explicit JSON tag
-	ID   int    `json:"id"`
-	Body string `json:"body"`
+	ID       int    `json:"id"`
+	Body     string `json:"body"`
+	AuthorID int    `json:"author_id"`
+	// Flagged marks a chirp that moderation wants a person to review
+	Flagged bool `json:"flagged"`
+	// InReplyToID is the chirp this one replies to, 0 if it starts a thread
+	InReplyToID int `json:"in_reply_to_id,omitempty"`
+	// RechirpOfID is the chirp this one re-shares without a body of its own.
+	// Rechirps can't be replied to or liked; those go to the original, and rechirps go when it does.
+	RechirpOfID int `json:"rechirp_of_id,omitempty"`
+	// QuoteOfID is the chirp this one quotes. Quotes keep the ID after the original is deleted.
+	QuoteOfID int `json:"quote_of_id,omitempty"`
+	// Tags are the distinct normalized hashtags in the body, sorted. The store indexes them, and only parses
+	// bodies itself to fill them in for chirps stored before hashtags were indexed.
+	Tags []string `json:"tags,omitempty"`
+	// Deleted marks a tombstone: a deleted chirp kept without its body or author so its replies stay in their thread
+	Deleted bool `json:"deleted,omitempty"`
+	// ReplyCount counts direct replies that are not deleted, and LikeCount the users who like the chirp.
+	// They are filled in when chirps are read and never stored.
+	ReplyCount int `json:"-"`
+	LikeCount  int `json:"-"`
+}
+
+// ChirpQuery selects a page of chirps
+type ChirpQuery struct {
+	// AuthorID limits results to one author, 0 means any author
+	AuthorID int
+	// Desc orders chirps newest (highest ID) first
+	Desc bool
+	// AfterID skips chirps up to and including this ID in the chosen order, 0 starts at the beginning
+	AfterID int
+	// Limit caps the number of chirps returned, 0 means no limit
+	Limit int
+	// FlaggedOnly limits results to chirps awaiting moderator review
+	FlaggedOnly bool
+	// TimelineOf limits results to chirps by this user and the users they follow, 0 means everyone
+	TimelineOf int
+	// DescendantsOf limits results to the replies to this chirp, the replies to those, and so on
+	DescendantsOf int
+	// IncludeDeleted includes tombstones, which are otherwise left out
+	IncludeDeleted bool
+	// LikedBy limits results to chirps this user likes, 0 means no limit
+	LikedBy int
+	// IDs limits results to these chirps, nil means no limit
+	IDs []int
+	// Tag limits results to chirps with this normalized hashtag, "" means no limit
+	Tag string
 }
 
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string) (Chirp, error) {
-	id := len(db.Data.Chirps) + 1
-	chirp := Chirp{
-		ID:   id,
-		Body: body,
+// matches reports whether chirp belongs in the query's results, ignoring Limit, TimelineOf, DescendantsOf, LikedBy, IDs and Tag
+func (q ChirpQuery) matches(chirp Chirp) bool {
+	if chirp.Deleted && !q.IncludeDeleted {
+		return false
+	}
+	if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+		return false
+	}
+	if q.FlaggedOnly && !chirp.Flagged {
+		return false
 	}
-	db.Data.Chirps[id] = chirp
-	err := db.writeDB(db.Data)
+	if q.AfterID != 0 {
+		if q.Desc && chirp.ID >= q.AfterID {
+			return false
+		}
+		if !q.Desc && chirp.ID <= q.AfterID {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateChirp saves a new chirp to disk; its ID is assigned by the database.
+// The chirps a new chirp replies to, rechirps or quotes must exist and not be deleted.
+// Pointing at a rechirp points at its original instead. A user can rechirp a chirp only once.
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, exists := db.data.Users[chirp.AuthorID]; !exists {
+		slog.Info("Attempted to create chirp for a user that does not exist", "user_id", chirp.AuthorID)
+		return Chirp{}, fmt.Errorf("chirp author ID %v: %w", chirp.AuthorID, ErrNotExist)
+	}
+	var err error
+	if chirp.InReplyToID != 0 {
+		chirp.InReplyToID, err = db.originalID(chirp.InReplyToID)
+		if err != nil {
+			return Chirp{}, fmt.Errorf("chirp parent: %w", err)
+		}
+	}
+	if chirp.RechirpOfID != 0 {
+		chirp.RechirpOfID, err = db.originalID(chirp.RechirpOfID)
+		if err != nil {
+			return Chirp{}, fmt.Errorf("chirp to rechirp: %w", err)
+		}
+	}
+	if chirp.QuoteOfID != 0 {
+		chirp.QuoteOfID, err = db.originalID(chirp.QuoteOfID)
+		if err != nil {
+			return Chirp{}, fmt.Errorf("chirp to quote: %w", err)
+		}
+	}
+	if chirp.RechirpOfID != 0 {
+		for _, rechirpID := range db.data.index.rechirps[chirp.RechirpOfID] {
+			if db.data.Chirps[rechirpID].AuthorID == chirp.AuthorID {
+				return Chirp{}, fmt.Errorf("user %v already rechirped %v: %w", chirp.AuthorID, chirp.RechirpOfID, ErrAlreadyExists)
+			}
+		}
+	}
+
+	id, seq := db.nextID(collectionChirps)
+	chirp.ID = id
+	chirp.Deleted = false
+	err = db.commit(seq, putRecord(collectionChirps, id, chirp))
 	if err != nil {
-		log.Printf("Failed to write new database")
+		slog.Error("Failed to write new chirp to database")
 		return Chirp{}, err
 	}
-	return chirp, nil
+	return db.withCounts(chirp), nil
 
 }
 
-// GetChirps returns all chirps in the database
-func (db *DB) GetChirps() ([]Chirp, error) {
-	chirps := make([]Chirp, 0, len(db.Data.Chirps))
+// GetChirps returns the page of chirps selected by query
+func (db *DB) GetChirps(query ChirpQuery) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-	for _, value := range db.Data.Chirps {
-		chirps = append(chirps, value)
+	var chirps []Chirp
+	if query.DescendantsOf != 0 {
+		if query.AuthorID != 0 || query.TimelineOf != 0 || query.LikedBy != 0 || query.Tag != "" {
+			return nil, errors.New("descendants can't be combined with an author, timeline, likes or tag")
+		}
+		chirps = db.collectChirps(db.descendants(query.DescendantsOf), query, []Chirp{})
+	} else if query.LikedBy != 0 {
+		if query.TimelineOf != 0 || query.Tag != "" {
+			return nil, errors.New("likes can't be combined with a timeline or tag")
+		}
+		chirps = db.collectChirps(db.data.index.liked[query.LikedBy], query, []Chirp{})
+	} else if query.Tag != "" {
+		if query.TimelineOf != 0 {
+			return nil, errors.New("a tag can't be combined with a timeline")
+		}
+		chirps = db.collectChirps(db.data.index.chirpsByTag[query.Tag], query, []Chirp{})
+	} else if query.IDs != nil {
+		ids := append([]int{}, query.IDs...)
+		sort.Ints(ids)
+		chirps = db.collectChirps(slices.Compact(ids), query, []Chirp{})
+	} else if authors := db.queryAuthors(query); authors != nil {
+		// only the listed authors' chirps can match, so read them from the index
+		chirps = []Chirp{}
+		for _, authorID := range authors {
+			chirps = db.collectChirps(db.data.index.chirpsByAuthor[authorID], query, chirps)
+		}
+	} else {
+		chirps = make([]Chirp, 0, len(db.data.Chirps))
+		for _, value := range db.data.Chirps {
+			if query.matches(value) {
+				chirps = append(chirps, value)
+			}
+		}
 	}
 
-	sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+	if query.Desc {
+		sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID > chirps[j].ID })
+	} else {
+		sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+	}
 
+	if query.Limit > 0 && len(chirps) > query.Limit {
+		chirps = chirps[:query.Limit]
+	}
+
+	for i := range chirps {
+		chirps[i] = db.withCounts(chirps[i])
+	}
 	return chirps, nil
 }
+
+// withCounts fills in the counts that are derived rather than stored.
+// The caller must hold db.mux.
+func (db *DB) withCounts(chirp Chirp) Chirp {
+	chirp.ReplyCount = 0
+	for _, replyID := range db.data.index.replies[chirp.ID] {
+		if !db.data.Chirps[replyID].Deleted {
+			chirp.ReplyCount++
+		}
+	}
+	chirp.LikeCount = len(db.data.index.likers[chirp.ID])
+	return chirp
+}
+
+// originalID follows a rechirp to the chirp it re-shares. It returns a wrapped ErrNotExist
+// if the chirp is missing or deleted. The caller must hold db.mux.
+func (db *DB) originalID(id int) (int, error) {
+	chirp, exists := db.data.Chirps[id]
+	if exists && chirp.RechirpOfID != 0 {
+		chirp, exists = db.data.Chirps[chirp.RechirpOfID]
+	}
+	if !exists || chirp.Deleted {
+		return 0, fmt.Errorf("chirp ID %v: %w", id, ErrNotExist)
+	}
+	return chirp.ID, nil
+}
+
+// descendants returns the IDs of every reply below id, in ascending order.
+// The caller must hold db.mux.
+func (db *DB) descendants(id int) []int {
+	ids := []int{}
+	pending := []int{id}
+	for len(pending) > 0 {
+		replies := db.data.index.replies[pending[0]]
+		pending = append(pending[1:], replies...)
+		ids = append(ids, replies...)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// queryAuthors returns the only authors whose chirps query can match,
+// or nil if chirps by anyone can match. The caller must hold db.mux.
+func (db *DB) queryAuthors(query ChirpQuery) []int {
+	if query.TimelineOf == 0 {
+		if query.AuthorID != 0 {
+			return []int{query.AuthorID}
+		}
+		return nil
+	}
+
+	authors := append(sortedKeys(db.data.index.following[query.TimelineOf]), query.TimelineOf)
+	if query.AuthorID != 0 {
+		for _, authorID := range authors {
+			if authorID == query.AuthorID {
+				return []int{authorID}
+			}
+		}
+		return []int{}
+	}
+	return authors
+}
+
+// collectChirps walks ascending chirp IDs in the query's order from its cursor,
+// appending matching chirps to chirps until it has added query.Limit of them.
+// The caller must hold db.mux.
+func (db *DB) collectChirps(ids []int, query ChirpQuery, chirps []Chirp) []Chirp {
+	added := 0
+	// take adds the chirp if it matches and reports whether to keep going
+	take := func(id int) bool {
+		// IDs passed in with query.IDs may not exist
+		chirp, exists := db.data.Chirps[id]
+		if exists && query.matches(chirp) {
+			chirps = append(chirps, chirp)
+			added++
+		}
+		return query.Limit == 0 || added < query.Limit
+	}
+
+	if query.Desc {
+		end := len(ids)
+		if query.AfterID != 0 {
+			end = sort.SearchInts(ids, query.AfterID)
+		}
+		for i := end - 1; i >= 0; i-- {
+			if !take(ids[i]) {
+				break
+			}
+		}
+	} else {
+		for i := sort.SearchInts(ids, query.AfterID+1); i < len(ids); i++ {
+			if !take(ids[i]) {
+				break
+			}
+		}
+	}
+	return chirps
+}
+
+// GetChirp returns the chirp with the given ID
+func (db *DB) GetChirp(id int) (Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	chirp, exists := db.data.Chirps[id]
+	if !exists {
+		return Chirp{}, ErrNotExist
+	}
+	return db.withCounts(chirp), nil
+}
+
+// DeleteChirp removes the chirp with the given ID.
+// A chirp with replies becomes a tombstone instead, and a tombstone goes once its last reply does.
+func (db *DB) DeleteChirp(id int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, exists := db.data.Chirps[id]
+	if !exists || chirp.Deleted {
+		return ErrNotExist
+	}
+
+	// a deleted chirp can't be liked, so its likes go whichever way it is deleted
+	records := db.unlikeAllRecords(id)
+	// and plain rechirps of it have nothing left to show
+	for _, rechirpID := range db.data.index.rechirps[id] {
+		records = append(records, deleteRecord(collectionChirps, rechirpID))
+	}
+	if len(db.data.index.replies[id]) > 0 {
+		records = append(records, putRecord(collectionChirps, id, tombstone(chirp)))
+	} else {
+		records = append(records, deleteRecord(collectionChirps, id))
+		// walk up through tombstones that were only kept for this branch
+		for parentID := chirp.InReplyToID; parentID != 0; {
+			parent := db.data.Chirps[parentID]
+			if !parent.Deleted || len(db.data.index.replies[parentID]) != 1 {
+				break
+			}
+			records = append(records, deleteRecord(collectionChirps, parentID))
+			parentID = parent.InReplyToID
+		}
+	}
+
+	err := db.commit(records...)
+	if err != nil {
+		slog.Error("Failed to write chirp deletion to database")
+		return err
+	}
+	return nil
+}
+
+// SetChirpFlagged sets whether a chirp is awaiting moderator review
+func (db *DB) SetChirpFlagged(id int, flagged bool) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, exists := db.data.Chirps[id]
+	if !exists {
+		return Chirp{}, ErrNotExist
+	}
+
+	chirp.Flagged = flagged
+	err := db.commit(putRecord(collectionChirps, id, chirp))
+	if err != nil {
+		slog.Error("Failed to write chirp flag to database")
+		return Chirp{}, err
+	}
+	return db.withCounts(chirp), nil
+}
+
+// tombstone returns what is kept of a deleted chirp that has replies
+func tombstone(chirp Chirp) Chirp {
+	return Chirp{ID: chirp.ID, InReplyToID: chirp.InReplyToID, Deleted: true}
+}
+
+// backfillTags fills in the tags of chirps stored before hashtags were indexed.
+// Chirps without hashtags can't be told apart from those, so their bodies are parsed again on every load.
+func (s *DBStructure) backfillTags() {
+	for id, chirp := range s.Chirps {
+		if chirp.Tags == nil && !chirp.Deleted {
+			chirp.Tags = hashtag.Tags(chirp.Body)
+			s.Chirps[id] = chirp
+		}
+	}
+}
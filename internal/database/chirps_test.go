@@ -0,0 +1,442 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestStores runs test against a fresh store of every driver
+func openTestStores(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Helper()
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			store, _ := openTestStore(t, driver)
+			t.Cleanup(func() { store.Close() })
+			test(t, store)
+		})
+	}
+}
+
+// mustCreateUsers creates a user for each email and returns their IDs in order
+func mustCreateUsers(t *testing.T, store Store, emails ...string) []int {
+	t.Helper()
+	ids := make([]int, 0, len(emails))
+	for _, email := range emails {
+		user, err := store.CreateUser(email, "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+// mustCreateChirp creates chirp, failing the test if it can't
+func mustCreateChirp(t *testing.T, store Store, chirp Chirp) Chirp {
+	t.Helper()
+	created, err := store.CreateChirp(chirp)
+	if err != nil {
+		t.Fatalf("create chirp %+v: %v", chirp, err)
+	}
+	return created
+}
+
+func TestRechirpOncePerUser(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		users := mustCreateUsers(t, store, "a@example.com", "b@example.com", "c@example.com")
+		original := mustCreateChirp(t, store, Chirp{Body: "original", AuthorID: users[0]})
+
+		rechirp := mustCreateChirp(t, store, Chirp{AuthorID: users[1], RechirpOfID: original.ID})
+		if rechirp.RechirpOfID != original.ID {
+			t.Errorf("rechirp is of %d, want %d", rechirp.RechirpOfID, original.ID)
+		}
+
+		_, err := store.CreateChirp(Chirp{AuthorID: users[1], RechirpOfID: original.ID})
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("second rechirp by the same user: %v, want ErrAlreadyExists", err)
+		}
+		// rechirping a rechirp rechirps its original, so it counts as the same rechirp
+		_, err = store.CreateChirp(Chirp{AuthorID: users[1], RechirpOfID: rechirp.ID})
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("rechirp of own rechirp: %v, want ErrAlreadyExists", err)
+		}
+
+		other := mustCreateChirp(t, store, Chirp{AuthorID: users[2], RechirpOfID: rechirp.ID})
+		if other.RechirpOfID != original.ID {
+			t.Errorf("rechirp of a rechirp is of %d, want the original %d", other.RechirpOfID, original.ID)
+		}
+
+		// undoing a rechirp allows it again
+		err = store.DeleteChirp(rechirp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustCreateChirp(t, store, Chirp{AuthorID: users[1], RechirpOfID: original.ID})
+
+		_, err = store.CreateChirp(Chirp{AuthorID: users[1], RechirpOfID: original.ID + 100})
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("rechirp of a missing chirp: %v, want ErrNotExist", err)
+		}
+	})
+}
+
+func TestQuoteOfRechirpQuotesOriginal(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		users := mustCreateUsers(t, store, "a@example.com", "b@example.com")
+		original := mustCreateChirp(t, store, Chirp{Body: "original", AuthorID: users[0]})
+		rechirp := mustCreateChirp(t, store, Chirp{AuthorID: users[1], RechirpOfID: original.ID})
+
+		quote := mustCreateChirp(t, store, Chirp{Body: "so true", AuthorID: users[1], QuoteOfID: rechirp.ID})
+		if quote.QuoteOfID != original.ID {
+			t.Errorf("quote of a rechirp is of %d, want the original %d", quote.QuoteOfID, original.ID)
+		}
+		// quotes are chirps of their own, so quoting twice is fine
+		mustCreateChirp(t, store, Chirp{Body: "still true", AuthorID: users[1], QuoteOfID: original.ID})
+
+		_, err := store.CreateChirp(Chirp{Body: "what?", AuthorID: users[1], QuoteOfID: original.ID + 100})
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("quote of a missing chirp: %v, want ErrNotExist", err)
+		}
+	})
+}
+
+func TestDeleteChirpCascades(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		users := mustCreateUsers(t, store, "a@example.com", "b@example.com", "c@example.com")
+		now := time.Now()
+
+		original := mustCreateChirp(t, store, Chirp{Body: "original #tag", AuthorID: users[0], Tags: []string{"tag"}})
+		rechirp := mustCreateChirp(t, store, Chirp{AuthorID: users[1], RechirpOfID: original.ID})
+		quote := mustCreateChirp(t, store, Chirp{Body: "so true", AuthorID: users[2], QuoteOfID: original.ID})
+		for _, userID := range users[1:] {
+			err := store.LikeChirp(userID, original.ID, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := store.DeleteChirp(original.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.GetChirp(original.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("deleted chirp without replies: %v, want ErrNotExist", err)
+		}
+		_, err = store.GetChirp(rechirp.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("rechirp of a deleted chirp: %v, want ErrNotExist", err)
+		}
+		kept, err := store.GetChirp(quote.ID)
+		if err != nil || kept.QuoteOfID != original.ID || kept.Body != "so true" {
+			t.Errorf("quote of a deleted chirp = %+v, %v, want it kept with its quote_of_id", kept, err)
+		}
+		for _, userID := range users[1:] {
+			liked, err := store.GetLikedChirpIDs(userID, []int{original.ID})
+			if err != nil || liked[original.ID] {
+				t.Errorf("user %d still likes the deleted chirp: %v, %v", userID, liked, err)
+			}
+			chirps, err := store.GetChirps(ChirpQuery{LikedBy: userID})
+			if err != nil || len(chirps) != 0 {
+				t.Errorf("user %d likes lists %d chirps, %v, want none", userID, len(chirps), err)
+			}
+		}
+		chirps, err := store.GetChirps(ChirpQuery{Tag: "tag"})
+		if err != nil || len(chirps) != 0 {
+			t.Errorf("tag lists %d chirps after delete, %v, want none", len(chirps), err)
+		}
+	})
+}
+
+func TestDeleteQuoteWithRepliesLeavesTombstone(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		users := mustCreateUsers(t, store, "a@example.com", "b@example.com")
+		original := mustCreateChirp(t, store, Chirp{Body: "original", AuthorID: users[0]})
+		quote := mustCreateChirp(t, store, Chirp{Body: "quoting #this", AuthorID: users[1], QuoteOfID: original.ID, Tags: []string{"this"}, Flagged: true})
+		mustCreateChirp(t, store, Chirp{Body: "reply", AuthorID: users[0], InReplyToID: quote.ID})
+		rechirp := mustCreateChirp(t, store, Chirp{AuthorID: users[0], RechirpOfID: quote.ID})
+		err := store.LikeChirp(users[0], quote.ID, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.DeleteChirp(quote.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// both drivers keep exactly the same of a deleted chirp: its place in the thread
+		got, err := store.GetChirp(quote.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Chirp{ID: quote.ID, Deleted: true, ReplyCount: 1}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("tombstone = %+v, want %+v", got, want)
+		}
+		_, err = store.GetChirp(rechirp.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("rechirp of a tombstoned chirp: %v, want ErrNotExist", err)
+		}
+		err = store.DeleteChirp(quote.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("deleting a tombstone: %v, want ErrNotExist", err)
+		}
+	})
+}
+
+func TestThreadDeletes(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		author := mustCreateUsers(t, store, "a@example.com")[0]
+		root := mustCreateChirp(t, store, Chirp{Body: "root", AuthorID: author})
+		parent := mustCreateChirp(t, store, Chirp{Body: "parent", AuthorID: author, InReplyToID: root.ID})
+		first := mustCreateChirp(t, store, Chirp{Body: "first", AuthorID: author, InReplyToID: parent.ID})
+		second := mustCreateChirp(t, store, Chirp{Body: "second", AuthorID: author, InReplyToID: parent.ID})
+
+		// thread returns the chirps below root, and replyCounts the reply count of each chirp that is still stored
+		thread := func(includeDeleted bool) []int {
+			t.Helper()
+			chirps, err := store.GetChirps(ChirpQuery{DescendantsOf: root.ID, IncludeDeleted: includeDeleted})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return chirpIDs(chirps)
+		}
+		replyCounts := func() map[int]int {
+			t.Helper()
+			chirps, err := store.GetChirps(ChirpQuery{IDs: []int{root.ID, parent.ID, first.ID, second.ID}, IncludeDeleted: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := make(map[int]int)
+			for _, chirp := range chirps {
+				counts[chirp.ID] = chirp.ReplyCount
+			}
+			return counts
+		}
+
+		if got, want := thread(false), []int{parent.ID, first.ID, second.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("thread: %v, want %v", got, want)
+		}
+		if got, want := replyCounts(), map[int]int{root.ID: 1, parent.ID: 2, first.ID: 0, second.ID: 0}; !reflect.DeepEqual(got, want) {
+			t.Errorf("reply counts: %v, want %v", got, want)
+		}
+
+		// a chirp with replies leaves a tombstone that only shows when asked for
+		err := store.DeleteChirp(parent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := thread(false), []int{first.ID, second.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("thread after deleting the parent: %v, want %v", got, want)
+		}
+		if got, want := thread(true), []int{parent.ID, first.ID, second.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("thread with tombstones after deleting the parent: %v, want %v", got, want)
+		}
+		// tombstones don't count as replies, but their replies still count for them
+		if got, want := replyCounts(), map[int]int{root.ID: 0, parent.ID: 2, first.ID: 0, second.ID: 0}; !reflect.DeepEqual(got, want) {
+			t.Errorf("reply counts after deleting the parent: %v, want %v", got, want)
+		}
+		err = store.DeleteChirp(parent.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("deleting a tombstone: %v, want ErrNotExist", err)
+		}
+
+		// the tombstone stays while it has a reply, and goes with the last one
+		err = store.DeleteChirp(first.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := thread(true), []int{parent.ID, second.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("thread with tombstones after deleting a reply: %v, want %v", got, want)
+		}
+		err = store.DeleteChirp(second.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := thread(true); len(got) != 0 {
+			t.Errorf("thread with tombstones after deleting the last reply: %v, want none", got)
+		}
+		// the walk up stops at a chirp that wasn't deleted
+		if got, want := replyCounts(), map[int]int{root.ID: 0}; !reflect.DeepEqual(got, want) {
+			t.Errorf("chirps left after deleting the last reply: %v, want %v", got, want)
+		}
+	})
+}
+
+func TestThreadWalkUpThroughTombstones(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		author := mustCreateUsers(t, store, "a@example.com")[0]
+		// a chain root <- middle <- leaf, with a second branch off root
+		root := mustCreateChirp(t, store, Chirp{Body: "root", AuthorID: author})
+		middle := mustCreateChirp(t, store, Chirp{Body: "middle", AuthorID: author, InReplyToID: root.ID})
+		leaf := mustCreateChirp(t, store, Chirp{Body: "leaf", AuthorID: author, InReplyToID: middle.ID})
+		branch := mustCreateChirp(t, store, Chirp{Body: "branch", AuthorID: author, InReplyToID: root.ID})
+
+		for _, id := range []int{root.ID, middle.ID} {
+			err := store.DeleteChirp(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stored := func() []int {
+			t.Helper()
+			chirps, err := store.GetChirps(ChirpQuery{IncludeDeleted: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return chirpIDs(chirps)
+		}
+
+		// deleting the leaf takes the middle tombstone with it, but root still has a reply
+		err := store.DeleteChirp(leaf.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := stored(), []int{root.ID, branch.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("after deleting the leaf: %v, want %v", got, want)
+		}
+		rootChirp, err := store.GetChirp(root.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rootChirp.Deleted || rootChirp.ReplyCount != 1 {
+			t.Errorf("root after deleting the leaf: %+v, want a tombstone with one reply", rootChirp)
+		}
+
+		// and deleting the other branch empties the thread
+		err = store.DeleteChirp(branch.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stored(); len(got) != 0 {
+			t.Errorf("after deleting both branches: %v, want nothing left", got)
+		}
+	})
+}
+
+// TestChirpCountsUseIndexes checks the counts every chirp is read with look up an index instead of scanning a table
+func TestChirpCountsUseIndexes(t *testing.T) {
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "chirpy_database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.conn.Query("EXPLAIN QUERY PLAN SELECT " + chirpColumns + " FROM chirps WHERE id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	plan := []string{}
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		err = rows.Scan(&id, &parent, &unused, &detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	if rows.Err() != nil {
+		t.Fatal(rows.Err())
+	}
+
+	joined := strings.Join(plan, "\n")
+	for _, want := range []string{
+		"SEARCH replies USING COVERING INDEX chirps_in_reply_to_id",
+		"SEARCH likes USING COVERING INDEX likes_chirp_id",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("query plan is missing %q:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "SCAN") {
+		t.Errorf("query plan scans a table:\n%s", joined)
+	}
+}
+
+func TestTagsBackfilledOnOpen(t *testing.T) {
+	// chirps posted before hashtags were indexed, in ID order
+	bodies := []string{"learning #Go", "no tags here", "#go and #SQLite"}
+
+	writeLegacy := map[string]func(t *testing.T, path string){
+		DriverJSON: func(t *testing.T, path string) {
+			chirps := make(map[int]Chirp)
+			for i, body := range bodies {
+				chirps[i+1] = Chirp{ID: i + 1, Body: body, AuthorID: 1}
+			}
+			data, err := json.Marshal(map[string]any{
+				"chirps": chirps,
+				"users":  map[int]User{1: {ID: 1, Email: "a@example.com"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(path, data, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+		DriverSQLite: func(t *testing.T, path string) {
+			db, err := NewSQLiteDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			_, err = db.conn.Exec("INSERT INTO users (email, hashed_password) VALUES ('a@example.com', 'hash')")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, body := range bodies {
+				_, err = db.conn.Exec("INSERT INTO chirps (body, author_id) VALUES (?, 1)", body)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// as if the backfill hadn't run yet
+			_, err = db.conn.Exec("PRAGMA user_version = 16")
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chirpy_database."+driver)
+			writeLegacy[driver](t, path)
+
+			store, err := Open(driver, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+
+			for tag, want := range map[string][]int{"go": {1, 3}, "sqlite": {3}} {
+				chirps, err := store.GetChirps(ChirpQuery{Tag: tag})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := chirpIDs(chirps); !reflect.DeepEqual(got, want) {
+					t.Errorf("chirps tagged %s: %v, want %v", tag, got, want)
+				}
+			}
+			chirp, err := store.GetChirp(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(chirp.Tags, []string{"go", "sqlite"}) {
+				t.Errorf("tags of chirp 3: %q, want go and sqlite", chirp.Tags)
+			}
+		})
+	}
+}
@@ -3,21 +3,41 @@ package database
 import (
 	"encoding/json"
 	"errors"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"sync"
 )
 
+// compactThreshold is how many log entries accumulate before they are folded into the snapshot
+const compactThreshold = 1000
+
 type DBStructure struct {
-	Chirps map[int]Chirp   `json:"chirps"`
-	Users  map[int]User `json:"users"`
+	Chirps        map[int]Chirp           `json:"chirps"`
+	Users         map[int]User            `json:"users"`
+	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	OneTimeTokens map[string]OneTimeToken `json:"one_time_tokens"`
+	AuditEvents   map[int]AuditEvent      `json:"audit_events"`
+	TOTP          map[int]TOTP            `json:"totp"`
+	Follows       map[string]Follow       `json:"follows"`
+	Likes         map[string]Like         `json:"likes"`
+	// Sequences holds the last ID handed out per collection so IDs are never reused
+	Sequences map[string]int `json:"sequences"`
+
+	index index
 }
 
+// DB is a Store backed by a JSON snapshot file plus an append-only
+// write-ahead log of every change made since the snapshot was taken.
+// All methods are safe for concurrent use: reads share mux, writes hold it exclusively.
 type DB struct {
-	path string
-	mux  *sync.RWMutex
-	Data DBStructure
+	path       string
+	mux        *sync.RWMutex
+	data       DBStructure
+	wal        *os.File
+	walSize    int64
+	walEntries int
+	closed     bool
 }
 
 // NewDB creates a new database connection
@@ -28,38 +48,69 @@ func NewDB(path string) (*DB, error) {
 		mux:  &sync.RWMutex{},
 	}
 
+	removeTempFiles(path)
+
 	err := newDB.ensureDB()
 	if err != nil {
-		log.Printf("Failed to create new database")
+		slog.Error("Failed to create new database")
+		return &newDB, err
+	}
+
+	newDB.data, err = newDB.loadDB()
+	if err != nil {
+		slog.Error("Failed to load new database")
 		return &newDB, err
 	}
 
-	newDB.Data, err = newDB.loadDB()
+	err = newDB.replayWAL()
 	if err != nil {
-		log.Printf("Failed to load new database")
+		slog.Error("Failed to replay write-ahead log")
+		return &newDB, err
+	}
+
+	newDB.data.lowercaseEmails()
+	newDB.data.backfillTags()
+	newDB.data.reindex()
+
+	// fold anything replayed into the snapshot and start with an empty log
+	err = newDB.compact()
+	if err != nil {
+		slog.Error("Failed to compact database")
 		return &newDB, err
 	}
 
 	return &newDB, nil
 }
 
+// newDBStructure returns an empty DBStructure with all maps initialized
+func newDBStructure() DBStructure {
+	return DBStructure{
+		Chirps:        make(map[int]Chirp),
+		Users:         make(map[int]User),
+		RefreshTokens: make(map[string]RefreshToken),
+		OneTimeTokens: make(map[string]OneTimeToken),
+		AuditEvents:   make(map[int]AuditEvent),
+		TOTP:          make(map[int]TOTP),
+		Follows:       make(map[string]Follow),
+		Likes:         make(map[string]Like),
+		Sequences:     make(map[string]int),
+		index:         newIndex(),
+	}
+}
+
 // ensureDB creates a new database file if it doesn't exist
 func (db *DB) ensureDB() error {
 	// check path for existing db file
 	_, err := os.Stat(db.path)
 	if errors.Is(err, os.ErrNotExist) {
 		// if not, create new data of type DBStructure and then write data to path
-		log.Printf("Database does not exist at path: %v", db.path)
-		log.Printf("Creating new database at path: %v", db.path)
+		slog.Info("Database does not exist, creating it", "path", db.path)
 
-		db.Data = DBStructure{
-			Chirps: make(map[int]Chirp),
-			Users:  make(map[int]User),
-		}
+		db.data = newDBStructure()
 
-		err := db.writeDB(db.Data)
+		err := db.writeDB(db.data)
 		if err != nil {
-			log.Printf("Failed to write new database")
+			slog.Error("Failed to write new database")
 			return err
 		}
 		return nil
@@ -69,63 +120,190 @@ func (db *DB) ensureDB() error {
 
 // loadDB reads the database file into memory
 func (db *DB) loadDB() (DBStructure, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
-
 	data, err := os.ReadFile(db.path)
 	if err != nil {
-		log.Printf("Failed to read database")
+		slog.Error("Failed to read database")
 		return DBStructure{}, err
 	}
 
-	err = json.Unmarshal(data, &db.Data)
+	dbStructure := newDBStructure()
+	err = json.Unmarshal(data, &dbStructure)
 	if err != nil {
-		log.Printf("Failed to unmarshal data")
+		slog.Error("Failed to unmarshal data")
 		return DBStructure{}, err
 	}
 
-	return db.Data, nil
+	// files written by older versions may be missing collections
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = make(map[int]Chirp)
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = make(map[int]User)
+	}
+	if dbStructure.RefreshTokens == nil {
+		dbStructure.RefreshTokens = make(map[string]RefreshToken)
+	}
+	if dbStructure.OneTimeTokens == nil {
+		dbStructure.OneTimeTokens = make(map[string]OneTimeToken)
+	}
+	if dbStructure.AuditEvents == nil {
+		dbStructure.AuditEvents = make(map[int]AuditEvent)
+	}
+	if dbStructure.TOTP == nil {
+		dbStructure.TOTP = make(map[int]TOTP)
+	}
+	if dbStructure.Follows == nil {
+		dbStructure.Follows = make(map[string]Follow)
+	}
+	if dbStructure.Likes == nil {
+		dbStructure.Likes = make(map[string]Like)
+	}
+	if dbStructure.Sequences == nil {
+		dbStructure.Sequences = make(map[string]int)
+	}
+	// never hand out an ID that is already taken, even if the sequence was lost
+	dbStructure.Sequences[collectionChirps] = max(dbStructure.Sequences[collectionChirps], maxKey(dbStructure.Chirps))
+	dbStructure.Sequences[collectionUsers] = max(dbStructure.Sequences[collectionUsers], maxKey(dbStructure.Users))
+	dbStructure.Sequences[collectionAuditEvents] = max(dbStructure.Sequences[collectionAuditEvents], maxKey(dbStructure.AuditEvents))
+	dbStructure.reindex()
+
+	return dbStructure, nil
+}
+
+// maxKey returns the largest key in m, or 0 if m is empty
+func maxKey[V any](m map[int]V) int {
+	highest := 0
+	for id := range m {
+		highest = max(highest, id)
+	}
+	return highest
 }
 
-// writeDB writes the database file to disk
+// nextID reserves the next ID of a collection.
+// It returns the ID along with the record that persists the reservation,
+// which must be committed together with the new record.
+// The caller must hold db.mux for writing.
+func (db *DB) nextID(collection string) (int, walRecord) {
+	id := db.data.Sequences[collection] + 1
+	return id, putRecord(collectionSequences, collection, id)
+}
+
+// writeDB atomically replaces the database file on disk.
+// The data goes to a temp file that is fsynced and then renamed over the
+// old file, so a crash leaves either the old or the new file, never a partial one.
 func (db *DB) writeDB(dbStructure DBStructure) error {
 	data, err := json.Marshal(dbStructure)
 	if err != nil {
-		log.Printf("Failed to marshal data")
+		slog.Error("Failed to marshal data")
 		return err
 	}
 
-	db.mux.Lock()
-	defer db.mux.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".tmp-*")
+	if err != nil {
+		slog.Error("Failed to create temp database file")
+		return err
+	}
+	// after a successful rename there is nothing left to remove
+	defer os.Remove(tmp.Name())
 
-	err = os.WriteFile(db.path, data, 0600)
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		log.Printf("Failed to write new database")
+		slog.Error("Failed to write temp database file")
 		return err
 	}
 
-	return nil
+	err = os.Rename(tmp.Name(), db.path)
+	if err != nil {
+		slog.Error("Failed to write new database")
+		return err
+	}
+
+	return syncDir(db.path)
+}
+
+// syncDir fsyncs the directory containing path so renames and new files survive a crash
+func syncDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
 }
 
-func (db *DB) DatabaseResetHandler(w http.ResponseWriter, req *http.Request) {
-	err := os.Remove(db.path)
+// removeTempFiles cleans up temp files left behind by a crash during writeDB
+func removeTempFiles(path string) {
+	matches, err := filepath.Glob(path + ".tmp-*")
 	if err != nil {
-		log.Fatal(err)
+		return
+	}
+	for _, match := range matches {
+		os.Remove(match)
+	}
+}
+
+// Reset empties the database.
+// ID sequences are kept so records created afterwards never reuse an old ID.
+func (db *DB) Reset() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return ErrClosed
 	}
 
-	err = db.ensureDB()
+	// empty the log first so a crash part way through can't replay old data onto the new snapshot
+	if db.wal != nil {
+		db.wal.Close()
+		db.wal = nil
+	}
+	err := db.openWAL(os.O_TRUNC)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	db.walEntries = 0
 
-	db.Data, err = db.loadDB()
+	sequences := db.data.Sequences
+	db.data = newDBStructure()
+	db.data.Sequences = sequences
+	err = db.writeDB(db.data)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Failed to write new database")
+		return err
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	return nil
+}
+
+// Close folds the write-ahead log into the snapshot and closes it
+func (db *DB) Close() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
 
-	w.WriteHeader(http.StatusOK)
+	err := db.compact()
+	if err != nil {
+		slog.Error("Failed to compact database on close")
+	}
 
-	w.Write([]byte("Database has been reset"))
+	var closeErr error
+	if db.wal != nil {
+		closeErr = db.wal.Close()
+		db.wal = nil
+	}
+	if err != nil {
+		return err
+	}
+	return closeErr
 }
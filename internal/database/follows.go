@@ -0,0 +1,103 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Follow records that one user follows another
+type Follow struct {
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// UserQuery selects a page of users, ordered by ID
+type UserQuery struct {
+	// AfterID skips users up to and including this ID, 0 starts at the beginning
+	AfterID int
+	// Limit caps the number of users returned, 0 means no limit
+	Limit int
+}
+
+// followKey is the key of a follow in the follows collection
+func followKey(followerID, followeeID int) string {
+	return fmt.Sprintf("%d:%d", followerID, followeeID)
+}
+
+// Follow makes followerID follow followeeID. Following someone already followed does nothing.
+func (db *DB) Follow(followerID, followeeID int, at time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	for _, id := range []int{followerID, followeeID} {
+		if _, exists := db.data.Users[id]; !exists {
+			return fmt.Errorf("follow user ID %v: %w", id, ErrNotExist)
+		}
+	}
+	key := followKey(followerID, followeeID)
+	if _, exists := db.data.Follows[key]; exists {
+		return nil
+	}
+
+	err := db.commit(putRecord(collectionFollows, key, Follow{FollowerID: followerID, FolloweeID: followeeID, CreatedAt: at}))
+	if err != nil {
+		slog.Error("Failed to write follow to database")
+		return err
+	}
+	return nil
+}
+
+// Unfollow makes followerID stop following followeeID. Unfollowing someone not followed does nothing.
+func (db *DB) Unfollow(followerID, followeeID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	key := followKey(followerID, followeeID)
+	if _, exists := db.data.Follows[key]; !exists {
+		return nil
+	}
+
+	err := db.commit(deleteRecord(collectionFollows, key))
+	if err != nil {
+		slog.Error("Failed to write unfollow to database")
+		return err
+	}
+	return nil
+}
+
+// GetFollowers returns a page of the users following userID
+func (db *DB) GetFollowers(userID int, query UserQuery) ([]User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.usersPage(db.data.index.followers[userID], query), nil
+}
+
+// GetFollowing returns a page of the users userID follows
+func (db *DB) GetFollowing(userID int, query UserQuery) ([]User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.usersPage(db.data.index.following[userID], query), nil
+}
+
+// usersPage returns the page of users in ids selected by query.
+// The caller must hold db.mux.
+func (db *DB) usersPage(ids map[int]bool, query UserQuery) []User {
+	sorted := sortedKeys(ids)
+	start := sort.SearchInts(sorted, query.AfterID+1)
+
+	users := []User{}
+	for _, id := range sorted[start:] {
+		if query.Limit > 0 && len(users) == query.Limit {
+			break
+		}
+		if user, exists := db.data.Users[id]; exists {
+			users = append(users, user)
+		}
+	}
+	return users
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// userIDs returns the IDs of users in order
+func userIDs(users []User) []int {
+	ids := []int{}
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+// chirpIDs returns the IDs of chirps in order
+func chirpIDs(chirps []Chirp) []int {
+	ids := []int{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
+
+func TestFollows(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		users := mustCreateUsers(t, store, "a@example.com", "b@example.com", "c@example.com")
+		a, b, c := users[0], users[1], users[2]
+
+		for _, followeeID := range []int{b, c, b} {
+			err := store.Follow(a, followeeID, time.Now().UTC())
+			if err != nil {
+				t.Fatalf("follow %d: %v", followeeID, err)
+			}
+		}
+		err := store.Follow(c, b, time.Now().UTC())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Follow(a, c+100, time.Now().UTC())
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("follow a missing user: %v, want ErrNotExist", err)
+		}
+
+		tests := []struct {
+			name  string
+			get   func(userID int, query UserQuery) ([]User, error)
+			user  int
+			query UserQuery
+			want  []int
+		}{
+			{"a follows", store.GetFollowing, a, UserQuery{}, []int{b, c}},
+			{"a follows, first page", store.GetFollowing, a, UserQuery{Limit: 1}, []int{b}},
+			{"a follows, next page", store.GetFollowing, a, UserQuery{AfterID: b, Limit: 1}, []int{c}},
+			{"b follows", store.GetFollowing, b, UserQuery{}, []int{}},
+			{"b's followers", store.GetFollowers, b, UserQuery{}, []int{a, c}},
+			{"c's followers", store.GetFollowers, c, UserQuery{}, []int{a}},
+		}
+		for _, tt := range tests {
+			got, err := tt.get(tt.user, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(userIDs(got), tt.want) {
+				t.Errorf("%s: %v, want %v", tt.name, userIDs(got), tt.want)
+			}
+		}
+
+		// unfollowing twice is no different from once
+		for range 2 {
+			err = store.Unfollow(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		following, err := store.GetFollowing(a, UserQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		followers, err := store.GetFollowers(b, UserQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(userIDs(following), []int{c}) || !reflect.DeepEqual(userIDs(followers), []int{c}) {
+			t.Errorf("after unfollowing: a follows %v and b's followers are %v, want [%d] and [%d]", userIDs(following), userIDs(followers), c, c)
+		}
+	})
+}
+
+func TestTimeline(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		users := mustCreateUsers(t, store, "a@example.com", "b@example.com", "c@example.com")
+		a, b, c := users[0], users[1], users[2]
+
+		a1 := mustCreateChirp(t, store, Chirp{Body: "a1", AuthorID: a})
+		b1 := mustCreateChirp(t, store, Chirp{Body: "b1", AuthorID: b})
+		mustCreateChirp(t, store, Chirp{Body: "c1", AuthorID: c})
+		b2 := mustCreateChirp(t, store, Chirp{Body: "b2", AuthorID: b})
+
+		timeline := func(query ChirpQuery) []int {
+			t.Helper()
+			query.TimelineOf = a
+			chirps, err := store.GetChirps(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return chirpIDs(chirps)
+		}
+
+		// without follows the timeline holds your own chirps
+		if got := timeline(ChirpQuery{Desc: true}); !reflect.DeepEqual(got, []int{a1.ID}) {
+			t.Errorf("timeline before following: %v, want [%d]", got, a1.ID)
+		}
+
+		err := store.Follow(a, b, time.Now().UTC())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := timeline(ChirpQuery{Desc: true}), []int{b2.ID, b1.ID, a1.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("timeline: %v, want %v", got, want)
+		}
+		if got, want := timeline(ChirpQuery{Desc: true, AfterID: b2.ID, Limit: 1}), []int{b1.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("timeline, second page: %v, want %v", got, want)
+		}
+		if got, want := timeline(ChirpQuery{}), []int{a1.ID, b1.ID, b2.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("timeline oldest first: %v, want %v", got, want)
+		}
+
+		// a deleted chirp leaves the timeline
+		err = store.DeleteChirp(b1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := timeline(ChirpQuery{Desc: true}), []int{b2.ID, a1.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("timeline after a delete: %v, want %v", got, want)
+		}
+
+		err = store.Unfollow(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := timeline(ChirpQuery{Desc: true}); !reflect.DeepEqual(got, []int{a1.ID}) {
+			t.Errorf("timeline after unfollowing: %v, want [%d]", got, a1.ID)
+		}
+	})
+}
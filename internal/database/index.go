@@ -0,0 +1,245 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+)
+
+// index holds lookups derived from the collections of a DBStructure so queries
+// don't have to scan a whole collection. It is never written to disk: it is
+// rebuilt when data is loaded and kept current by applyEntry.
+type index struct {
+	// usersByEmail maps each email to its user's ID. Emails are stored lowercased;
+	// a user whose stored email isn't can't be found by it.
+	usersByEmail map[string]int
+	// chirpsByAuthor holds each author's chirp IDs in ascending order; tombstones have no author
+	chirpsByAuthor map[int][]int
+	// chirpsByTag holds the IDs of the chirps with each hashtag in ascending order
+	chirpsByTag map[string][]int
+	// replies holds the IDs of each chirp's direct replies, tombstones included, in ascending order
+	replies map[int][]int
+	// rechirps holds the IDs of each chirp's plain rechirps in ascending order
+	rechirps map[int][]int
+	// following maps a follower to the users they follow
+	following map[int]map[int]bool
+	// followers maps a user to the users following them
+	followers map[int]map[int]bool
+	// likers maps a chirp to the users who like it
+	likers map[int]map[int]bool
+	// liked holds the IDs of the chirps each user likes in ascending order
+	liked map[int][]int
+}
+
+func newIndex() index {
+	return index{
+		usersByEmail:   make(map[string]int),
+		chirpsByAuthor: make(map[int][]int),
+		chirpsByTag:    make(map[string][]int),
+		replies:        make(map[int][]int),
+		rechirps:       make(map[int][]int),
+		following:      make(map[int]map[int]bool),
+		followers:      make(map[int]map[int]bool),
+		likers:         make(map[int]map[int]bool),
+		liked:          make(map[int][]int),
+	}
+}
+
+// reindex rebuilds the index from the collections
+func (s *DBStructure) reindex() {
+	s.index = newIndex()
+	for _, user := range s.Users {
+		s.index.addUser(user)
+	}
+	// in ID order, so every insert into the sorted lists is an append
+	for _, id := range sortedKeys(s.Chirps) {
+		s.index.addChirp(s.Chirps[id])
+	}
+	for _, follow := range s.Follows {
+		s.index.addFollow(follow)
+	}
+	for _, like := range s.Likes {
+		s.index.addLike(like)
+	}
+}
+
+// applyUser applies one users record, keeping the index in step
+func (s *DBStructure) applyUser(key string, value []byte) error {
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return err
+	}
+	if old, exists := s.Users[id]; exists {
+		s.index.removeUser(old)
+	}
+	err = applyRecord(s.Users, id, value)
+	if err != nil {
+		return err
+	}
+	if user, exists := s.Users[id]; exists {
+		s.index.addUser(user)
+	}
+	return nil
+}
+
+func (ix index) addUser(user User) {
+	if user.Email == normalizeEmail(user.Email) {
+		ix.usersByEmail[user.Email] = user.ID
+	}
+}
+
+func (ix index) removeUser(user User) {
+	if ix.usersByEmail[user.Email] == user.ID {
+		delete(ix.usersByEmail, user.Email)
+	}
+}
+
+// applyChirp applies one chirps record, keeping the index in step
+func (s *DBStructure) applyChirp(key string, value []byte) error {
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return err
+	}
+	if old, exists := s.Chirps[id]; exists {
+		s.index.removeChirp(old)
+	}
+	err = applyRecord(s.Chirps, id, value)
+	if err != nil {
+		return err
+	}
+	if chirp, exists := s.Chirps[id]; exists {
+		s.index.addChirp(chirp)
+	}
+	return nil
+}
+
+func (ix index) addChirp(chirp Chirp) {
+	if !chirp.Deleted {
+		ix.chirpsByAuthor[chirp.AuthorID] = insertSorted(ix.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	}
+	for _, tag := range chirp.Tags {
+		ix.chirpsByTag[tag] = insertSorted(ix.chirpsByTag[tag], chirp.ID)
+	}
+	if chirp.InReplyToID != 0 {
+		ix.replies[chirp.InReplyToID] = insertSorted(ix.replies[chirp.InReplyToID], chirp.ID)
+	}
+	if chirp.RechirpOfID != 0 {
+		ix.rechirps[chirp.RechirpOfID] = insertSorted(ix.rechirps[chirp.RechirpOfID], chirp.ID)
+	}
+}
+
+func (ix index) removeChirp(chirp Chirp) {
+	ix.chirpsByAuthor[chirp.AuthorID] = removeSorted(ix.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	if len(ix.chirpsByAuthor[chirp.AuthorID]) == 0 {
+		delete(ix.chirpsByAuthor, chirp.AuthorID)
+	}
+	for _, tag := range chirp.Tags {
+		ix.chirpsByTag[tag] = removeSorted(ix.chirpsByTag[tag], chirp.ID)
+		if len(ix.chirpsByTag[tag]) == 0 {
+			delete(ix.chirpsByTag, tag)
+		}
+	}
+	if chirp.InReplyToID != 0 {
+		ix.replies[chirp.InReplyToID] = removeSorted(ix.replies[chirp.InReplyToID], chirp.ID)
+		if len(ix.replies[chirp.InReplyToID]) == 0 {
+			delete(ix.replies, chirp.InReplyToID)
+		}
+	}
+	if chirp.RechirpOfID != 0 {
+		ix.rechirps[chirp.RechirpOfID] = removeSorted(ix.rechirps[chirp.RechirpOfID], chirp.ID)
+		if len(ix.rechirps[chirp.RechirpOfID]) == 0 {
+			delete(ix.rechirps, chirp.RechirpOfID)
+		}
+	}
+}
+
+// applyFollow applies one follows record, keeping the index in step
+func (s *DBStructure) applyFollow(key string, value []byte) error {
+	if old, exists := s.Follows[key]; exists {
+		delete(s.index.following[old.FollowerID], old.FolloweeID)
+		delete(s.index.followers[old.FolloweeID], old.FollowerID)
+	}
+	err := applyRecord(s.Follows, key, value)
+	if err != nil {
+		return err
+	}
+	if follow, exists := s.Follows[key]; exists {
+		s.index.addFollow(follow)
+	}
+	return nil
+}
+
+func (ix index) addFollow(follow Follow) {
+	if ix.following[follow.FollowerID] == nil {
+		ix.following[follow.FollowerID] = make(map[int]bool)
+	}
+	ix.following[follow.FollowerID][follow.FolloweeID] = true
+	if ix.followers[follow.FolloweeID] == nil {
+		ix.followers[follow.FolloweeID] = make(map[int]bool)
+	}
+	ix.followers[follow.FolloweeID][follow.FollowerID] = true
+}
+
+// applyLike applies one likes record, keeping the index in step
+func (s *DBStructure) applyLike(key string, value []byte) error {
+	if old, exists := s.Likes[key]; exists {
+		s.index.removeLike(old)
+	}
+	err := applyRecord(s.Likes, key, value)
+	if err != nil {
+		return err
+	}
+	if like, exists := s.Likes[key]; exists {
+		s.index.addLike(like)
+	}
+	return nil
+}
+
+func (ix index) addLike(like Like) {
+	if ix.likers[like.ChirpID] == nil {
+		ix.likers[like.ChirpID] = make(map[int]bool)
+	}
+	ix.likers[like.ChirpID][like.UserID] = true
+	ix.liked[like.UserID] = insertSorted(ix.liked[like.UserID], like.ChirpID)
+}
+
+func (ix index) removeLike(like Like) {
+	delete(ix.likers[like.ChirpID], like.UserID)
+	if len(ix.likers[like.ChirpID]) == 0 {
+		delete(ix.likers, like.ChirpID)
+	}
+	ix.liked[like.UserID] = removeSorted(ix.liked[like.UserID], like.ChirpID)
+	if len(ix.liked[like.UserID]) == 0 {
+		delete(ix.liked, like.UserID)
+	}
+}
+
+// insertSorted adds id to ascending ids. New IDs are always the largest, so this is usually an append.
+func insertSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+// removeSorted removes id from ascending ids
+func removeSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	return append(ids[:i], ids[i+1:]...)
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Like records that a user likes a chirp
+type Like struct {
+	UserID    int       `json:"user_id"`
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// likeKey is the key of a like in the likes collection
+func likeKey(userID, chirpID int) string {
+	return fmt.Sprintf("%d:%d", userID, chirpID)
+}
+
+// LikeChirp makes userID like chirpID, or the original if it is a rechirp. Liking a chirp already liked does nothing.
+func (db *DB) LikeChirp(userID, chirpID int, at time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, exists := db.data.Users[userID]; !exists {
+		return fmt.Errorf("like user ID %v: %w", userID, ErrNotExist)
+	}
+	chirpID, err := db.originalID(chirpID)
+	if err != nil {
+		return fmt.Errorf("like: %w", err)
+	}
+	key := likeKey(userID, chirpID)
+	if _, exists := db.data.Likes[key]; exists {
+		return nil
+	}
+
+	err = db.commit(putRecord(collectionLikes, key, Like{UserID: userID, ChirpID: chirpID, CreatedAt: at}))
+	if err != nil {
+		slog.Error("Failed to write like to database")
+		return err
+	}
+	return nil
+}
+
+// UnlikeChirp makes userID stop liking chirpID, or the original if it is a rechirp. Unliking a chirp not liked does nothing.
+func (db *DB) UnlikeChirp(userID, chirpID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if chirp := db.data.Chirps[chirpID]; chirp.RechirpOfID != 0 {
+		chirpID = chirp.RechirpOfID
+	}
+	key := likeKey(userID, chirpID)
+	if _, exists := db.data.Likes[key]; !exists {
+		return nil
+	}
+
+	err := db.commit(deleteRecord(collectionLikes, key))
+	if err != nil {
+		slog.Error("Failed to write unlike to database")
+		return err
+	}
+	return nil
+}
+
+// GetLikedChirpIDs reports which of chirpIDs userID likes
+func (db *DB) GetLikedChirpIDs(userID int, chirpIDs []int) (map[int]bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	liked := make(map[int]bool)
+	for _, id := range chirpIDs {
+		if db.data.index.likers[id][userID] {
+			liked[id] = true
+		}
+	}
+	return liked, nil
+}
+
+// unlikeAllRecords returns the records that remove every like of chirpID.
+// The caller must hold db.mux.
+func (db *DB) unlikeAllRecords(chirpID int) []walRecord {
+	records := []walRecord{}
+	for _, userID := range sortedKeys(db.data.index.likers[chirpID]) {
+		records = append(records, deleteRecord(collectionLikes, likeKey(userID, chirpID)))
+	}
+	return records
+}
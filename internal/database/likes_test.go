@@ -0,0 +1,91 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLikes(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		users := mustCreateUsers(t, store, "a@example.com", "b@example.com")
+		a, b := users[0], users[1]
+		liked := mustCreateChirp(t, store, Chirp{Body: "liked", AuthorID: a})
+		other := mustCreateChirp(t, store, Chirp{Body: "other", AuthorID: a})
+		rechirp := mustCreateChirp(t, store, Chirp{AuthorID: b, RechirpOfID: liked.ID})
+
+		likeCount := func(id int) int {
+			t.Helper()
+			chirp, err := store.GetChirp(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return chirp.LikeCount
+		}
+		likedBy := func(userID int) []int {
+			t.Helper()
+			chirps, err := store.GetChirps(ChirpQuery{LikedBy: userID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return chirpIDs(chirps)
+		}
+
+		// liking twice is no different from once, and liking a rechirp likes its original
+		for _, like := range []struct{ user, chirp int }{{a, liked.ID}, {b, liked.ID}, {b, liked.ID}, {a, rechirp.ID}} {
+			err := store.LikeChirp(like.user, like.chirp, time.Now().UTC())
+			if err != nil {
+				t.Fatalf("user %d likes %d: %v", like.user, like.chirp, err)
+			}
+		}
+		err := store.LikeChirp(a, other.ID+100, time.Now().UTC())
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("like a missing chirp: %v, want ErrNotExist", err)
+		}
+
+		if got := likeCount(liked.ID); got != 2 {
+			t.Errorf("like count %d, want 2", got)
+		}
+		if got := likeCount(other.ID); got != 0 {
+			t.Errorf("like count of a chirp nobody likes %d, want 0", got)
+		}
+		got, err := store.GetLikedChirpIDs(b, []int{liked.ID, other.ID, rechirp.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[int]bool{liked.ID: true}; !reflect.DeepEqual(got, want) {
+			t.Errorf("chirps b likes: %v, want %v", got, want)
+		}
+		if got, want := likedBy(b), []int{liked.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("listing of b's likes: %v, want %v", got, want)
+		}
+
+		// unliking twice is no different from once, and unliking a rechirp unlikes its original
+		for range 2 {
+			err = store.UnlikeChirp(b, rechirp.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := likeCount(liked.ID); got != 1 {
+			t.Errorf("like count after unliking %d, want 1", got)
+		}
+		if got := likedBy(b); len(got) != 0 {
+			t.Errorf("listing of b's likes after unliking: %v, want none", got)
+		}
+
+		// a deleted chirp takes its likes with it
+		err = store.DeleteChirp(liked.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = store.GetLikedChirpIDs(a, []int{liked.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 || len(likedBy(a)) != 0 {
+			t.Errorf("a still likes %v and lists %v after the chirp was deleted", got, likedBy(a))
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrTokenUsed is returned when redeeming a one-time token a second time
+var ErrTokenUsed = errors.New("token has already been used")
+
+// OneTimeToken records a single-use token, such as an emailed verification link.
+// The token itself is a signed JWT; only its ID is stored so it can be redeemed once.
+type OneTimeToken struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsedAt    time.Time `json:"used_at,omitempty"`
+}
+
+// CreateOneTimeToken stores a newly issued one-time token
+func (db *DB) CreateOneTimeToken(token OneTimeToken) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, exists := db.data.OneTimeTokens[token.ID]; exists {
+		return fmt.Errorf("one-time token: %w", ErrAlreadyExists)
+	}
+	if _, exists := db.data.Users[token.UserID]; !exists {
+		return fmt.Errorf("one-time token user ID %v: %w", token.UserID, ErrNotExist)
+	}
+
+	err := db.commit(putRecord(collectionOneTimeTokens, token.ID, token))
+	if err != nil {
+		slog.Error("Failed to write one-time token to database")
+		return err
+	}
+	return nil
+}
+
+// UseOneTimeToken marks the token with the given ID and purpose as used and returns it.
+// It returns ErrTokenUsed if the token was already redeemed.
+func (db *DB) UseOneTimeToken(id, purpose string, usedAt time.Time) (OneTimeToken, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	token, exists := db.data.OneTimeTokens[id]
+	if !exists || token.Purpose != purpose {
+		return OneTimeToken{}, ErrNotExist
+	}
+	if !token.UsedAt.IsZero() {
+		return OneTimeToken{}, ErrTokenUsed
+	}
+
+	token.UsedAt = usedAt
+	err := db.commit(putRecord(collectionOneTimeTokens, id, token))
+	if err != nil {
+		slog.Error("Failed to write used one-time token to database")
+		return OneTimeToken{}, err
+	}
+	return token, nil
+}
+
+// DiscardOneTimeTokens marks every unused token a user holds for purpose as used,
+// so links sent earlier stop working
+func (db *DB) DiscardOneTimeTokens(userID int, purpose string, at time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	records := []walRecord{}
+	for id, token := range db.data.OneTimeTokens {
+		if token.UserID != userID || token.Purpose != purpose || !token.UsedAt.IsZero() {
+			continue
+		}
+		token.UsedAt = at
+		records = append(records, putRecord(collectionOneTimeTokens, id, token))
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	err := db.commit(records...)
+	if err != nil {
+		slog.Error("Failed to write discarded one-time tokens to database")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package database
+
+// Role decides which admin features a user may use
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// roleRank orders roles so that each one includes the permissions of those below it
+var roleRank = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// Valid reports whether r is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// AtLeast reports whether r grants everything min does.
+// Users stored before roles existed have an empty role, which counts as RoleUser.
+func (r Role) AtLeast(min Role) bool {
+	if r == "" {
+		r = RoleUser
+	}
+	return roleRank[r] >= roleRank[min]
+}
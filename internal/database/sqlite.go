@@ -0,0 +1,1254 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/hashtag"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteMigrations are applied in order; PRAGMA user_version records how many have run.
+// Tables use AUTOINCREMENT so a deleted row's ID is never handed out again.
+var sqliteMigrations = []string{
+	`CREATE TABLE chirps (
+		id   INTEGER PRIMARY KEY AUTOINCREMENT,
+		body TEXT NOT NULL
+	);
+	CREATE TABLE users (
+		id              INTEGER PRIMARY KEY AUTOINCREMENT,
+		email           TEXT NOT NULL UNIQUE,
+		hashed_password TEXT NOT NULL
+	);`,
+	// chirps posted before authors were tracked keep a NULL author_id
+	`ALTER TABLE chirps ADD COLUMN author_id INTEGER REFERENCES users(id);
+	CREATE INDEX chirps_author_id ON chirps(author_id);`,
+	// refresh tokens are stored hashed so they can be rotated and revoked
+	`CREATE TABLE refresh_tokens (
+		token_hash  TEXT PRIMARY KEY,
+		user_id     INTEGER NOT NULL REFERENCES users(id),
+		family_id   TEXT NOT NULL,
+		issued_at   TIMESTAMP NOT NULL,
+		expires_at  TIMESTAMP NOT NULL,
+		revoked_at  TIMESTAMP,
+		replaced_by TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX refresh_tokens_family_id ON refresh_tokens(family_id);
+	CREATE INDEX refresh_tokens_user_id ON refresh_tokens(user_id);`,
+	`ALTER TABLE users ADD COLUMN is_chirpy_red BOOLEAN NOT NULL DEFAULT FALSE;`,
+	`ALTER TABLE chirps ADD COLUMN flagged BOOLEAN NOT NULL DEFAULT FALSE;
+	CREATE INDEX chirps_flagged ON chirps(id) WHERE flagged;`,
+	`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';`,
+	`ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+	CREATE TABLE one_time_tokens (
+		id         TEXT PRIMARY KEY,
+		user_id    INTEGER NOT NULL REFERENCES users(id),
+		purpose    TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at    TIMESTAMP
+	);
+	CREATE INDEX one_time_tokens_user_id ON one_time_tokens(user_id);`,
+	`CREATE TABLE audit_events (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id     INTEGER NOT NULL REFERENCES users(id),
+		type        TEXT NOT NULL,
+		remote_addr TEXT NOT NULL,
+		created_at  TIMESTAMP NOT NULL
+	);
+	CREATE INDEX audit_events_user_id ON audit_events(user_id);`,
+	`CREATE TABLE totp (
+		user_id         INTEGER PRIMARY KEY REFERENCES users(id),
+		secret          BLOB NOT NULL,
+		enabled_at      TIMESTAMP,
+		last_step       INTEGER NOT NULL DEFAULT 0,
+		failed_attempts INTEGER NOT NULL DEFAULT 0,
+		last_failed_at  TIMESTAMP
+	);
+	CREATE TABLE recovery_codes (
+		user_id   INTEGER NOT NULL REFERENCES users(id),
+		code_hash TEXT NOT NULL,
+		used_at   TIMESTAMP,
+		PRIMARY KEY (user_id, code_hash)
+	);`,
+	// the primary key serves "who does X follow", the index "who follows X"
+	`CREATE TABLE follows (
+		follower_id INTEGER NOT NULL REFERENCES users(id),
+		followee_id INTEGER NOT NULL REFERENCES users(id),
+		created_at  TIMESTAMP NOT NULL,
+		PRIMARY KEY (follower_id, followee_id)
+	);
+	CREATE INDEX follows_followee_id ON follows(followee_id, follower_id);`,
+	`ALTER TABLE chirps ADD COLUMN in_reply_to_id INTEGER REFERENCES chirps(id);
+	ALTER TABLE chirps ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT FALSE;
+	CREATE INDEX chirps_in_reply_to_id ON chirps(in_reply_to_id);`,
+	// the primary key serves "what does X like", the index counts a chirp's likes
+	`CREATE TABLE likes (
+		user_id    INTEGER NOT NULL REFERENCES users(id),
+		chirp_id   INTEGER NOT NULL REFERENCES chirps(id),
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, chirp_id)
+	);
+	CREATE INDEX likes_chirp_id ON likes(chirp_id, user_id);`,
+	// quote_of_id is not a foreign key because quotes outlive the chirps they quote.
+	// The unique index allows one rechirp of a chirp per user and finds a chirp's rechirps.
+	`ALTER TABLE chirps ADD COLUMN rechirp_of_id INTEGER REFERENCES chirps(id);
+	ALTER TABLE chirps ADD COLUMN quote_of_id INTEGER;
+	CREATE UNIQUE INDEX chirps_rechirp_of_id ON chirps(rechirp_of_id, author_id) WHERE rechirp_of_id IS NOT NULL;`,
+	// the primary key pages through a tag's chirps, the index reads a chirp's tags
+	`CREATE TABLE chirp_tags (
+		tag      TEXT NOT NULL,
+		chirp_id INTEGER NOT NULL REFERENCES chirps(id),
+		PRIMARY KEY (tag, chirp_id)
+	);
+	CREATE INDEX chirp_tags_chirp_id ON chirp_tags(chirp_id);`,
+	// emails are lowercased in Go, see sqliteDataMigrations
+	"",
+	// with deleted in the index, counting a chirp's replies doesn't read the replies themselves
+	`DROP INDEX chirps_in_reply_to_id;
+	CREATE INDEX chirps_in_reply_to_id ON chirps(in_reply_to_id, deleted);`,
+	// hashtags of chirps posted before they were indexed are filled in in Go, see sqliteDataMigrations
+	"",
+}
+
+// sqliteDataMigrations holds the steps that need Go rather than SQL, keyed by
+// the migration they belong to. Each runs after that migration's SQL, in the
+// same transaction.
+var sqliteDataMigrations = map[int]func(tx *sql.Tx) error{
+	15: lowercaseSQLiteEmails,
+	17: backfillSQLiteTags,
+}
+
+// SQLiteDB is a Store backed by an embedded SQLite database
+type SQLiteDB struct {
+	path string
+	conn *sql.DB
+}
+
+// NewSQLiteDB opens the SQLite database at path,
+// creating it and bringing its schema up to date if needed
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		slog.Error("Failed to open sqlite database")
+		return nil, err
+	}
+
+	db := &SQLiteDB{
+		path: path,
+		conn: conn,
+	}
+
+	err = db.migrate()
+	if err != nil {
+		slog.Error("Failed to migrate sqlite database")
+		conn.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migrate applies any migrations the database has not seen yet
+func (db *SQLiteDB) migrate() error {
+	var version int
+	err := db.conn.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		if sqliteMigrations[i] != "" {
+			_, err = tx.Exec(sqliteMigrations[i])
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %w", i+1, err)
+			}
+		}
+		if step, exists := sqliteDataMigrations[i+1]; exists {
+			err = step(tx)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %w", i+1, err)
+			}
+		}
+		// PRAGMA does not accept bound parameters
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lowercaseSQLiteEmails stores the emails of users registered before emails were lowercased in lowercase
+func lowercaseSQLiteEmails(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, email FROM users ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user := User{}
+		err = rows.Scan(&user.ID, &user.Email)
+		if err != nil {
+			return err
+		}
+		users = append(users, user)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for id, email := range lowercaseEmails(users) {
+		_, err = tx.Exec("UPDATE users SET email = ? WHERE id = ?", email, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillSQLiteTags indexes the hashtags of chirps posted before hashtags were indexed
+func backfillSQLiteTags(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, body FROM chirps WHERE NOT deleted AND id NOT IN (SELECT chirp_id FROM chirp_tags)")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tags := make(map[int][]string)
+	for rows.Next() {
+		var id int
+		var body string
+		err = rows.Scan(&id, &body)
+		if err != nil {
+			return err
+		}
+		tags[id] = hashtag.Tags(body)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for id, chirpTags := range tags {
+		for _, tag := range chirpTags {
+			_, err = tx.Exec("INSERT INTO chirp_tags (tag, chirp_id) VALUES (?, ?) ON CONFLICT DO NOTHING", tag, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sqliteErr translates driver errors into the store's sentinel errors
+func sqliteErr(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotExist
+	}
+	var sqliteError sqlite3.Error
+	if errors.As(err, &sqliteError) && sqliteError.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, err)
+	}
+	if errors.As(err, &sqliteError) && sqliteError.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+		return fmt.Errorf("%w: %s", ErrNotExist, err)
+	}
+	return err
+}
+
+// chirpColumns lists the columns scanChirp expects, in order.
+// It must be selected from the chirps table without an alias.
+const chirpColumns = `id, body, COALESCE(author_id, 0), flagged, COALESCE(in_reply_to_id, 0), deleted,
+	COALESCE(rechirp_of_id, 0), COALESCE(quote_of_id, 0),
+	(SELECT COUNT(*) FROM chirps AS replies WHERE replies.in_reply_to_id = chirps.id AND NOT replies.deleted),
+	(SELECT COUNT(*) FROM likes WHERE likes.chirp_id = chirps.id),
+	(SELECT json_group_array(tag) FROM chirp_tags WHERE chirp_tags.chirp_id = chirps.id)`
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanChirp(row scanner) (Chirp, error) {
+	chirp := Chirp{}
+	var tags string
+	err := row.Scan(&chirp.ID, &chirp.Body, &chirp.AuthorID, &chirp.Flagged, &chirp.InReplyToID, &chirp.Deleted,
+		&chirp.RechirpOfID, &chirp.QuoteOfID, &chirp.ReplyCount, &chirp.LikeCount, &tags)
+	if err != nil {
+		return chirp, err
+	}
+	err = json.Unmarshal([]byte(tags), &chirp.Tags)
+	if err != nil {
+		return chirp, err
+	}
+	if len(chirp.Tags) == 0 {
+		chirp.Tags = nil
+	}
+	sort.Strings(chirp.Tags)
+	return chirp, nil
+}
+
+// chirpQueryWhere builds the WHERE clause selecting query's chirps
+func chirpQueryWhere(query ChirpQuery) (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	if !query.IncludeDeleted {
+		conditions = append(conditions, "NOT deleted")
+	}
+	if query.AuthorID != 0 {
+		conditions = append(conditions, "author_id = ?")
+		args = append(args, query.AuthorID)
+	}
+	if query.FlaggedOnly {
+		conditions = append(conditions, "flagged")
+	}
+	if query.TimelineOf != 0 {
+		// chirps_author_id lets SQLite seek each author's chirps by ID instead of scanning the table
+		conditions = append(conditions, "(author_id = ? OR author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?))")
+		args = append(args, query.TimelineOf, query.TimelineOf)
+	}
+	if query.DescendantsOf != 0 {
+		conditions = append(conditions, `id IN (
+			WITH RECURSIVE thread(id) AS (
+				SELECT id FROM chirps WHERE in_reply_to_id = ?
+				UNION ALL
+				SELECT chirps.id FROM chirps JOIN thread ON chirps.in_reply_to_id = thread.id
+			)
+			SELECT id FROM thread)`)
+		args = append(args, query.DescendantsOf)
+	}
+	if query.LikedBy != 0 {
+		conditions = append(conditions, "id IN (SELECT chirp_id FROM likes WHERE user_id = ?)")
+		args = append(args, query.LikedBy)
+	}
+	if query.Tag != "" {
+		conditions = append(conditions, "id IN (SELECT chirp_id FROM chirp_tags WHERE tag = ?)")
+		args = append(args, query.Tag)
+	}
+	if query.IDs != nil {
+		conditions = append(conditions, "id IN (SELECT value FROM json_each(?))")
+		args = append(args, jsonIDs(query.IDs))
+	}
+	if query.AfterID != 0 {
+		if query.Desc {
+			conditions = append(conditions, "id < ?")
+		} else {
+			conditions = append(conditions, "id > ?")
+		}
+		args = append(args, query.AfterID)
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// jsonIDs formats ids as a JSON array for json_each. A list of IDs is passed as one
+// parameter like this because it can be longer than the number of parameters SQLite allows.
+func jsonIDs(ids []int) string {
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, strconv.Itoa(id))
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+// chirpQueryOrder builds the ORDER BY and LIMIT clauses for query
+func chirpQueryOrder(query ChirpQuery) string {
+	order := " ORDER BY id"
+	if query.Desc {
+		order += " DESC"
+	}
+	if query.Limit > 0 {
+		order += " LIMIT " + strconv.Itoa(query.Limit)
+	}
+	return order
+}
+
+// CreateChirp inserts a new chirp; its ID is assigned by the database.
+// The chirps a new chirp replies to, rechirps or quotes must exist and not be deleted.
+// Pointing at a rechirp points at its original instead. A user can rechirp a chirp only once.
+func (db *SQLiteDB) CreateChirp(chirp Chirp) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	if chirp.InReplyToID != 0 {
+		chirp.InReplyToID, err = originalChirpID(tx, chirp.InReplyToID)
+		if err != nil {
+			return Chirp{}, fmt.Errorf("chirp parent: %w", err)
+		}
+	}
+	if chirp.RechirpOfID != 0 {
+		chirp.RechirpOfID, err = originalChirpID(tx, chirp.RechirpOfID)
+		if err != nil {
+			return Chirp{}, fmt.Errorf("chirp to rechirp: %w", err)
+		}
+	}
+	if chirp.QuoteOfID != 0 {
+		chirp.QuoteOfID, err = originalChirpID(tx, chirp.QuoteOfID)
+		if err != nil {
+			return Chirp{}, fmt.Errorf("chirp to quote: %w", err)
+		}
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO chirps (body, author_id, flagged, in_reply_to_id, rechirp_of_id, quote_of_id) VALUES (?, ?, ?, ?, ?, ?)",
+		chirp.Body, chirp.AuthorID, chirp.Flagged, nullID(chirp.InReplyToID), nullID(chirp.RechirpOfID), nullID(chirp.QuoteOfID),
+	)
+	if err != nil {
+		slog.Error("Failed to insert new chirp")
+		return Chirp{}, sqliteErr(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	for _, tag := range chirp.Tags {
+		_, err = tx.Exec("INSERT INTO chirp_tags (tag, chirp_id) VALUES (?, ?) ON CONFLICT DO NOTHING", tag, id)
+		if err != nil {
+			slog.Error("Failed to insert chirp tag")
+			return Chirp{}, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Chirp{}, err
+	}
+	return db.GetChirp(int(id))
+}
+
+// originalChirpID follows a rechirp to the chirp it re-shares. It returns a wrapped ErrNotExist
+// if the chirp is missing or deleted.
+func originalChirpID(tx *sql.Tx, id int) (int, error) {
+	var originalID int
+	var deleted bool
+	err := tx.QueryRow(
+		"SELECT id, deleted FROM chirps WHERE id = (SELECT COALESCE(rechirp_of_id, id) FROM chirps WHERE id = ?)", id,
+	).Scan(&originalID, &deleted)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && deleted) {
+		return 0, fmt.Errorf("chirp ID %v: %w", id, ErrNotExist)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return originalID, nil
+}
+
+// nullID stores the zero ID as NULL
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// GetChirps returns the page of chirps selected by query
+func (db *SQLiteDB) GetChirps(query ChirpQuery) ([]Chirp, error) {
+	where, args := chirpQueryWhere(query)
+	rows, err := db.conn.Query("SELECT "+chirpColumns+" FROM chirps"+where+chirpQueryOrder(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// GetChirp returns the chirp with the given ID
+func (db *SQLiteDB) GetChirp(id int) (Chirp, error) {
+	chirp, err := scanChirp(db.conn.QueryRow("SELECT "+chirpColumns+" FROM chirps WHERE id = ?", id))
+	if err != nil {
+		return Chirp{}, sqliteErr(err)
+	}
+	return chirp, nil
+}
+
+// DeleteChirp removes the chirp with the given ID.
+// A chirp with replies becomes a tombstone instead, and a tombstone goes once its last reply does.
+func (db *SQLiteDB) DeleteChirp(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID int
+	var deleted, hasReplies bool
+	err = tx.QueryRow(
+		`SELECT COALESCE(in_reply_to_id, 0), deleted, EXISTS (SELECT 1 FROM chirps AS replies WHERE replies.in_reply_to_id = chirps.id)
+		FROM chirps WHERE id = ?`, id,
+	).Scan(&parentID, &deleted, &hasReplies)
+	if err != nil {
+		return sqliteErr(err)
+	}
+	if deleted {
+		return ErrNotExist
+	}
+
+	// a deleted chirp can't be liked or found by its tags, whichever way it is deleted
+	_, err = tx.Exec("DELETE FROM likes WHERE chirp_id = ?", id)
+	if err != nil {
+		slog.Error("Failed to delete likes of chirp")
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM chirp_tags WHERE chirp_id = ?", id)
+	if err != nil {
+		slog.Error("Failed to delete tags of chirp")
+		return err
+	}
+	// and plain rechirps of it have nothing left to show
+	_, err = tx.Exec("DELETE FROM chirps WHERE rechirp_of_id = ?", id)
+	if err != nil {
+		slog.Error("Failed to delete rechirps of chirp")
+		return err
+	}
+
+	if hasReplies {
+		_, err = tx.Exec("UPDATE chirps SET body = '', author_id = NULL, flagged = FALSE, quote_of_id = NULL, rechirp_of_id = NULL, deleted = TRUE WHERE id = ?", id)
+		if err != nil {
+			slog.Error("Failed to turn chirp into a tombstone")
+			return err
+		}
+		return tx.Commit()
+	}
+
+	_, err = tx.Exec("DELETE FROM chirps WHERE id = ?", id)
+	if err != nil {
+		slog.Error("Failed to delete chirp")
+		return err
+	}
+
+	// walk up through tombstones that were only kept for this branch
+	for parentID != 0 {
+		var grandparentID int
+		err = tx.QueryRow(
+			`SELECT COALESCE(in_reply_to_id, 0) FROM chirps
+			WHERE id = ? AND deleted AND NOT EXISTS (SELECT 1 FROM chirps AS replies WHERE replies.in_reply_to_id = chirps.id)`, parentID,
+		).Scan(&grandparentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM chirps WHERE id = ?", parentID)
+		if err != nil {
+			slog.Error("Failed to delete tombstone")
+			return err
+		}
+		parentID = grandparentID
+	}
+
+	return tx.Commit()
+}
+
+// SetChirpFlagged sets whether a chirp is awaiting moderator review
+func (db *SQLiteDB) SetChirpFlagged(id int, flagged bool) (Chirp, error) {
+	res, err := db.conn.Exec("UPDATE chirps SET flagged = ? WHERE id = ?", flagged, id)
+	if err != nil {
+		slog.Error("Failed to update chirp flag")
+		return Chirp{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Chirp{}, err
+	}
+	if n == 0 {
+		return Chirp{}, ErrNotExist
+	}
+	return db.GetChirp(id)
+}
+
+// userColumns lists the columns scanUser expects, in order
+const userColumns = "id, email, hashed_password, is_chirpy_red, role, verified"
+
+func scanUser(row scanner) (User, error) {
+	user := User{}
+	err := row.Scan(&user.ID, &user.Email, &user.HashedPassword, &user.IsChirpyRed, &user.Role, &user.Verified)
+	return user, err
+}
+
+// CreateUser creates a new user, the email must not already be registered
+func (db *SQLiteDB) CreateUser(email, hashedPassword string) (User, error) {
+	email = normalizeEmail(email)
+	res, err := db.conn.Exec("INSERT INTO users (email, hashed_password) VALUES (?, ?)", email, hashedPassword)
+	if err != nil {
+		slog.Error("Failed to insert new user")
+		return User{}, sqliteErr(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return db.GetUser(int(id))
+}
+
+// UpdateUser replaces the email and password of an existing user
+func (db *SQLiteDB) UpdateUser(id int, email, hashedPassword string) (User, error) {
+	email = normalizeEmail(email)
+	// a changed address has to be verified again; the right-hand sides see the old row
+	res, err := db.conn.Exec(
+		"UPDATE users SET verified = (verified AND email = ?), email = ?, hashed_password = ? WHERE id = ?",
+		email, email, hashedPassword, id,
+	)
+	if err != nil {
+		slog.Error("Failed to update user")
+		return User{}, sqliteErr(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+	if n == 0 {
+		slog.Info("Attempted to update a user that does not exist", "user_id", id)
+		return User{}, fmt.Errorf("attempted to update user ID %v: %w", id, ErrNotExist)
+	}
+	return db.GetUser(id)
+}
+
+// SetChirpyRed sets whether a user has a Chirpy Red membership
+func (db *SQLiteDB) SetChirpyRed(id int, isChirpyRed bool) (User, error) {
+	res, err := db.conn.Exec("UPDATE users SET is_chirpy_red = ? WHERE id = ?", isChirpyRed, id)
+	if err != nil {
+		slog.Error("Failed to update Chirpy Red membership")
+		return User{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+	if n == 0 {
+		return User{}, fmt.Errorf("attempted to update user ID %v: %w", id, ErrNotExist)
+	}
+	return db.GetUser(id)
+}
+
+// SetUserVerified sets whether a user's email address has been verified
+func (db *SQLiteDB) SetUserVerified(id int, verified bool) (User, error) {
+	res, err := db.conn.Exec("UPDATE users SET verified = ? WHERE id = ?", verified, id)
+	if err != nil {
+		slog.Error("Failed to update user verification")
+		return User{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+	if n == 0 {
+		return User{}, fmt.Errorf("attempted to update user ID %v: %w", id, ErrNotExist)
+	}
+	return db.GetUser(id)
+}
+
+// SetUserRole changes a user's role
+func (db *SQLiteDB) SetUserRole(id int, role Role) (User, error) {
+	res, err := db.conn.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		slog.Error("Failed to update user role")
+		return User{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+	if n == 0 {
+		return User{}, fmt.Errorf("attempted to update user ID %v: %w", id, ErrNotExist)
+	}
+	return db.GetUser(id)
+}
+
+// GetUser returns the user with the given ID
+func (db *SQLiteDB) GetUser(id int) (User, error) {
+	user, err := scanUser(db.conn.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		return User{}, sqliteErr(err)
+	}
+	return user, nil
+}
+
+// GetUserByEmail returns the user registered with the given email
+func (db *SQLiteDB) GetUserByEmail(email string) (User, error) {
+	user, err := scanUser(db.conn.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", normalizeEmail(email)))
+	if err != nil {
+		return User{}, sqliteErr(err)
+	}
+	return user, nil
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// CreateRefreshToken stores a newly issued refresh token
+func (db *SQLiteDB) CreateRefreshToken(token RefreshToken) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO refresh_tokens (token_hash, user_id, family_id, issued_at, expires_at, revoked_at, replaced_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		token.TokenHash, token.UserID, token.FamilyID, token.IssuedAt, token.ExpiresAt, nullTime(token.RevokedAt), token.ReplacedBy,
+	)
+	if err != nil {
+		slog.Error("Failed to insert refresh token")
+		return sqliteErr(err)
+	}
+	return nil
+}
+
+// GetRefreshToken returns the refresh token with the given hash
+func (db *SQLiteDB) GetRefreshToken(tokenHash string) (RefreshToken, error) {
+	token := RefreshToken{}
+	var revokedAt sql.NullTime
+	err := db.conn.QueryRow(
+		`SELECT token_hash, user_id, family_id, issued_at, expires_at, revoked_at, replaced_by
+		FROM refresh_tokens WHERE token_hash = ?`, tokenHash,
+	).Scan(&token.TokenHash, &token.UserID, &token.FamilyID, &token.IssuedAt, &token.ExpiresAt, &revokedAt, &token.ReplacedBy)
+	if err != nil {
+		return RefreshToken{}, sqliteErr(err)
+	}
+	token.RevokedAt = revokedAt.Time
+	return token, nil
+}
+
+// RotateRefreshToken marks the old token as used and stores its replacement in one transaction.
+// It returns ErrTokenRevoked if the old token was already used or revoked.
+func (db *SQLiteDB) RotateRefreshToken(oldHash string, next RefreshToken, rotatedAt time.Time) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var revokedAt sql.NullTime
+	err = tx.QueryRow("SELECT revoked_at FROM refresh_tokens WHERE token_hash = ?", oldHash).Scan(&revokedAt)
+	if err != nil {
+		return sqliteErr(err)
+	}
+	if revokedAt.Valid {
+		return ErrTokenRevoked
+	}
+
+	_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = ?, replaced_by = ? WHERE token_hash = ?", rotatedAt, next.TokenHash, oldHash)
+	if err != nil {
+		slog.Error("Failed to revoke rotated refresh token")
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO refresh_tokens (token_hash, user_id, family_id, issued_at, expires_at, revoked_at, replaced_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		next.TokenHash, next.UserID, next.FamilyID, next.IssuedAt, next.ExpiresAt, nullTime(next.RevokedAt), next.ReplacedBy,
+	)
+	if err != nil {
+		slog.Error("Failed to insert rotated refresh token")
+		return sqliteErr(err)
+	}
+
+	return tx.Commit()
+}
+
+// RevokeRefreshToken revokes a single refresh token
+func (db *SQLiteDB) RevokeRefreshToken(tokenHash string, revokedAt time.Time) error {
+	res, err := db.conn.Exec("UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, ?) WHERE token_hash = ?", revokedAt, tokenHash)
+	if err != nil {
+		slog.Error("Failed to revoke refresh token")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token rotated from the same login
+func (db *SQLiteDB) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	_, err := db.conn.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL", revokedAt, familyID)
+	if err != nil {
+		slog.Error("Failed to revoke refresh token family")
+		return err
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token issued to a user, signing them out everywhere
+func (db *SQLiteDB) RevokeUserRefreshTokens(userID int, revokedAt time.Time) error {
+	_, err := db.conn.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", revokedAt, userID)
+	if err != nil {
+		slog.Error("Failed to revoke user refresh tokens")
+		return err
+	}
+	return nil
+}
+
+// CreateOneTimeToken stores a newly issued one-time token
+func (db *SQLiteDB) CreateOneTimeToken(token OneTimeToken) error {
+	_, err := db.conn.Exec(
+		"INSERT INTO one_time_tokens (id, user_id, purpose, expires_at, used_at) VALUES (?, ?, ?, ?, ?)",
+		token.ID, token.UserID, token.Purpose, token.ExpiresAt, nullTime(token.UsedAt),
+	)
+	if err != nil {
+		slog.Error("Failed to insert one-time token")
+		return sqliteErr(err)
+	}
+	return nil
+}
+
+// UseOneTimeToken marks the token with the given ID and purpose as used and returns it.
+// It returns ErrTokenUsed if the token was already redeemed.
+func (db *SQLiteDB) UseOneTimeToken(id, purpose string, usedAt time.Time) (OneTimeToken, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+	defer tx.Rollback()
+
+	token := OneTimeToken{}
+	var used sql.NullTime
+	err = tx.QueryRow(
+		"SELECT id, user_id, purpose, expires_at, used_at FROM one_time_tokens WHERE id = ? AND purpose = ?", id, purpose,
+	).Scan(&token.ID, &token.UserID, &token.Purpose, &token.ExpiresAt, &used)
+	if err != nil {
+		return OneTimeToken{}, sqliteErr(err)
+	}
+	if used.Valid {
+		return OneTimeToken{}, ErrTokenUsed
+	}
+
+	_, err = tx.Exec("UPDATE one_time_tokens SET used_at = ? WHERE id = ?", usedAt, id)
+	if err != nil {
+		slog.Error("Failed to mark one-time token used")
+		return OneTimeToken{}, err
+	}
+	token.UsedAt = usedAt
+	return token, tx.Commit()
+}
+
+// DiscardOneTimeTokens marks every unused token a user holds for purpose as used,
+// so links sent earlier stop working
+func (db *SQLiteDB) DiscardOneTimeTokens(userID int, purpose string, at time.Time) error {
+	_, err := db.conn.Exec(
+		"UPDATE one_time_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL", at, userID, purpose,
+	)
+	if err != nil {
+		slog.Error("Failed to discard one-time tokens")
+		return err
+	}
+	return nil
+}
+
+// CreateAuditEvent stores an audit event; its ID is assigned by the database
+func (db *SQLiteDB) CreateAuditEvent(event AuditEvent) (AuditEvent, error) {
+	res, err := db.conn.Exec(
+		"INSERT INTO audit_events (user_id, type, remote_addr, created_at) VALUES (?, ?, ?, ?)",
+		event.UserID, event.Type, event.RemoteAddr, event.CreatedAt,
+	)
+	if err != nil {
+		slog.Error("Failed to insert audit event")
+		return AuditEvent{}, sqliteErr(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	event.ID = int(id)
+	return event, nil
+}
+
+// GetTOTP returns a user's TOTP enrollment, finished or not
+func (db *SQLiteDB) GetTOTP(userID int) (TOTP, error) {
+	totp := TOTP{}
+	var enabledAt, lastFailedAt sql.NullTime
+	err := db.conn.QueryRow(
+		`SELECT user_id, secret, enabled_at, last_step, failed_attempts, last_failed_at
+		FROM totp WHERE user_id = ?`, userID,
+	).Scan(&totp.UserID, &totp.Secret, &enabledAt, &totp.LastStep, &totp.FailedAttempts, &lastFailedAt)
+	if err != nil {
+		return TOTP{}, sqliteErr(err)
+	}
+	totp.EnabledAt = enabledAt.Time
+	totp.LastFailedAt = lastFailedAt.Time
+
+	rows, err := db.conn.Query("SELECT code_hash, used_at FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return TOTP{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		code := RecoveryCode{}
+		var usedAt sql.NullTime
+		err = rows.Scan(&code.Hash, &usedAt)
+		if err != nil {
+			return TOTP{}, err
+		}
+		code.UsedAt = usedAt.Time
+		totp.RecoveryCodes = append(totp.RecoveryCodes, code)
+	}
+	return totp, rows.Err()
+}
+
+// StartTOTPEnrollment stores a new, not yet enabled, secret for a user, replacing any unfinished enrollment.
+// It returns ErrAlreadyExists if the user already has TOTP enabled.
+func (db *SQLiteDB) StartTOTPEnrollment(userID int, secret []byte) error {
+	res, err := db.conn.Exec(
+		`INSERT INTO totp (user_id, secret) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, last_step = 0, failed_attempts = 0, last_failed_at = NULL
+		WHERE enabled_at IS NULL`, userID, secret,
+	)
+	if err != nil {
+		slog.Error("Failed to insert totp enrollment")
+		return sqliteErr(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+// EnableTOTP finishes a user's enrollment, accepting the code at step and storing the hashes of their recovery codes.
+// It returns ErrAlreadyExists if TOTP is already enabled.
+func (db *SQLiteDB) EnableTOTP(userID int, step int64, recoveryCodeHashes []string, enabledAt time.Time) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var enabled sql.NullTime
+	err = tx.QueryRow("SELECT enabled_at FROM totp WHERE user_id = ?", userID).Scan(&enabled)
+	if err != nil {
+		return sqliteErr(err)
+	}
+	if enabled.Valid {
+		return ErrAlreadyExists
+	}
+
+	_, err = tx.Exec("UPDATE totp SET enabled_at = ?, last_step = ?, failed_attempts = 0 WHERE user_id = ?", enabledAt, step, userID)
+	if err != nil {
+		slog.Error("Failed to enable totp")
+		return err
+	}
+
+	// left over from an enrollment that was turned off
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash)
+		if err != nil {
+			slog.Error("Failed to insert recovery code")
+			return sqliteErr(err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep accepts a code from the given time step and clears failed attempts.
+// It returns ErrTokenUsed if a code from that step or a later one was already accepted.
+func (db *SQLiteDB) UseTOTPStep(userID int, step int64) error {
+	res, err := db.conn.Exec("UPDATE totp SET last_step = ?, failed_attempts = 0 WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		slog.Error("Failed to update totp step")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// tell a missing enrollment apart from a replayed code
+	var exists bool
+	err = db.conn.QueryRow("SELECT EXISTS (SELECT 1 FROM totp WHERE user_id = ?)", userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotExist
+	}
+	return ErrTokenUsed
+}
+
+// UseRecoveryCode marks the recovery code with the given hash as used and clears failed attempts.
+// It returns ErrNotExist if the user has no such code and ErrTokenUsed if it was used before.
+func (db *SQLiteDB) UseRecoveryCode(userID int, hash string, usedAt time.Time) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var used sql.NullTime
+	err = tx.QueryRow("SELECT used_at FROM recovery_codes WHERE user_id = ? AND code_hash = ?", userID, hash).Scan(&used)
+	if err != nil {
+		return sqliteErr(err)
+	}
+	if used.Valid {
+		return ErrTokenUsed
+	}
+
+	_, err = tx.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ?", usedAt, userID, hash)
+	if err != nil {
+		slog.Error("Failed to mark recovery code used")
+		return err
+	}
+	_, err = tx.Exec("UPDATE totp SET failed_attempts = 0 WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordTOTPAttempt counts a code before it is checked, so parallel guesses can't all get in under the limit.
+// Accepting the code clears the count again. It returns ErrLocked, counting nothing, when maxFailures codes
+// have been tried and lockout has not passed since the last of them.
+func (db *SQLiteDB) RecordTOTPAttempt(userID int, at time.Time, maxFailures int, lockout time.Duration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var failedAttempts int
+	var lastFailedAt sql.NullTime
+	err = tx.QueryRow("SELECT failed_attempts, last_failed_at FROM totp WHERE user_id = ?", userID).Scan(&failedAttempts, &lastFailedAt)
+	if err != nil {
+		return sqliteErr(err)
+	}
+	if failedAttempts >= maxFailures && at.Before(lastFailedAt.Time.Add(lockout)) {
+		return ErrLocked
+	}
+
+	_, err = tx.Exec("UPDATE totp SET failed_attempts = failed_attempts + 1, last_failed_at = ? WHERE user_id = ?", at, userID)
+	if err != nil {
+		slog.Error("Failed to record totp attempt")
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteTOTP removes a user's enrollment and recovery codes, turning two-factor authentication off
+func (db *SQLiteDB) DeleteTOTP(userID int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM totp WHERE user_id = ?", userID)
+	if err != nil {
+		slog.Error("Failed to delete totp")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		slog.Error("Failed to delete recovery codes")
+		return err
+	}
+	return tx.Commit()
+}
+
+// Follow makes followerID follow followeeID. Following someone already followed does nothing.
+func (db *SQLiteDB) Follow(followerID, followeeID int, at time.Time) error {
+	_, err := db.conn.Exec(
+		"INSERT INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		followerID, followeeID, at,
+	)
+	if err != nil {
+		slog.Error("Failed to insert follow")
+		return sqliteErr(err)
+	}
+	return nil
+}
+
+// Unfollow makes followerID stop following followeeID. Unfollowing someone not followed does nothing.
+func (db *SQLiteDB) Unfollow(followerID, followeeID int) error {
+	_, err := db.conn.Exec("DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerID, followeeID)
+	if err != nil {
+		slog.Error("Failed to delete follow")
+		return err
+	}
+	return nil
+}
+
+// GetFollowers returns a page of the users following userID
+func (db *SQLiteDB) GetFollowers(userID int, query UserQuery) ([]User, error) {
+	return db.usersPage("SELECT follower_id FROM follows WHERE followee_id = ?", userID, query)
+}
+
+// GetFollowing returns a page of the users userID follows
+func (db *SQLiteDB) GetFollowing(userID int, query UserQuery) ([]User, error) {
+	return db.usersPage("SELECT followee_id FROM follows WHERE follower_id = ?", userID, query)
+}
+
+// usersPage returns the page of users whose IDs the subquery selects
+func (db *SQLiteDB) usersPage(subquery string, arg any, query UserQuery) ([]User, error) {
+	statement := "SELECT " + userColumns + " FROM users WHERE id IN (" + subquery + ") AND id > ? ORDER BY id"
+	if query.Limit > 0 {
+		statement += " LIMIT " + strconv.Itoa(query.Limit)
+	}
+	rows, err := db.conn.Query(statement, arg, query.AfterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// LikeChirp makes userID like chirpID, or the original if it is a rechirp. Liking a chirp already liked does nothing.
+func (db *SQLiteDB) LikeChirp(userID, chirpID int, at time.Time) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	chirpID, err = originalChirpID(tx, chirpID)
+	if err != nil {
+		return fmt.Errorf("like: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO likes (user_id, chirp_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		userID, chirpID, at,
+	)
+	if err != nil {
+		slog.Error("Failed to insert like")
+		return sqliteErr(err)
+	}
+	return tx.Commit()
+}
+
+// UnlikeChirp makes userID stop liking chirpID, or the original if it is a rechirp. Unliking a chirp not liked does nothing.
+func (db *SQLiteDB) UnlikeChirp(userID, chirpID int) error {
+	_, err := db.conn.Exec(
+		"DELETE FROM likes WHERE user_id = ? AND chirp_id = (SELECT COALESCE(rechirp_of_id, id) FROM chirps WHERE id = ?)",
+		userID, chirpID,
+	)
+	if err != nil {
+		slog.Error("Failed to delete like")
+		return err
+	}
+	return nil
+}
+
+// GetLikedChirpIDs reports which of chirpIDs userID likes
+func (db *SQLiteDB) GetLikedChirpIDs(userID int, chirpIDs []int) (map[int]bool, error) {
+	liked := make(map[int]bool)
+	if len(chirpIDs) == 0 {
+		return liked, nil
+	}
+
+	rows, err := db.conn.Query("SELECT chirp_id FROM likes WHERE user_id = ? AND chirp_id IN (SELECT value FROM json_each(?))", userID, jsonIDs(chirpIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		liked[id] = true
+	}
+	return liked, rows.Err()
+}
+
+// Stats counts the rows of each table, treating tokens that expire before now as inactive
+func (db *SQLiteDB) Stats(now time.Time) (Stats, error) {
+	stats := Stats{}
+	err := db.conn.QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM chirps WHERE NOT deleted),
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM refresh_tokens WHERE revoked_at IS NULL AND expires_at > ?)`, now,
+	).Scan(&stats.Chirps, &stats.Users, &stats.ActiveRefreshTokens)
+	if err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
+
+// Reset deletes every row from every table.
+// AUTOINCREMENT counters live in sqlite_sequence, which is left alone so IDs are never reused.
+func (db *SQLiteDB) Reset() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"chirp_tags", "likes", "follows", "recovery_codes", "totp", "audit_events", "one_time_tokens", "refresh_tokens", "chirps", "users"} {
+		_, err = tx.Exec("DELETE FROM " + table)
+		if err != nil {
+			slog.Error("Failed to reset table", "table", table)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close closes the underlying database connection
+func (db *SQLiteDB) Close() error {
+	return db.conn.Close()
+}
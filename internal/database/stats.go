@@ -0,0 +1,32 @@
+package database
+
+import "time"
+
+// Stats are record totals reported by the store for monitoring
+type Stats struct {
+	Chirps int
+	Users  int
+	// ActiveRefreshTokens counts tokens that are neither revoked nor expired
+	ActiveRefreshTokens int
+}
+
+// Stats counts the records in the database, treating tokens that expire before now as inactive
+func (db *DB) Stats(now time.Time) (Stats, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	stats := Stats{
+		Users: len(db.data.Users),
+	}
+	for _, chirp := range db.data.Chirps {
+		if !chirp.Deleted {
+			stats.Chirps++
+		}
+	}
+	for _, token := range db.data.RefreshTokens {
+		if !token.Revoked() && token.ExpiresAt.After(now) {
+			stats.ActiveRefreshTokens++
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotExist is returned when a requested record is not in the store
+var ErrNotExist = errors.New("record does not exist")
+
+// ErrAlreadyExists is returned when a record would violate a uniqueness constraint
+var ErrAlreadyExists = errors.New("record already exists")
+
+// ErrClosed is returned when writing to a store after Close
+var ErrClosed = errors.New("database is closed")
+
+// Store is the persistence layer the chirpy handlers depend on.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	CreateChirp(chirp Chirp) (Chirp, error)
+	GetChirps(query ChirpQuery) ([]Chirp, error)
+	GetChirp(id int) (Chirp, error)
+	DeleteChirp(id int) error
+	SetChirpFlagged(id int, flagged bool) (Chirp, error)
+
+	CreateUser(email, hashedPassword string) (User, error)
+	UpdateUser(id int, email, hashedPassword string) (User, error)
+	GetUser(id int) (User, error)
+	GetUserByEmail(email string) (User, error)
+	SetChirpyRed(id int, isChirpyRed bool) (User, error)
+	SetUserRole(id int, role Role) (User, error)
+	SetUserVerified(id int, verified bool) (User, error)
+
+	Follow(followerID, followeeID int, at time.Time) error
+	Unfollow(followerID, followeeID int) error
+	GetFollowers(userID int, query UserQuery) ([]User, error)
+	GetFollowing(userID int, query UserQuery) ([]User, error)
+
+	LikeChirp(userID, chirpID int, at time.Time) error
+	UnlikeChirp(userID, chirpID int) error
+	// GetLikedChirpIDs reports which of chirpIDs userID likes
+	GetLikedChirpIDs(userID int, chirpIDs []int) (map[int]bool, error)
+
+	CreateRefreshToken(token RefreshToken) error
+	GetRefreshToken(tokenHash string) (RefreshToken, error)
+	RotateRefreshToken(oldHash string, next RefreshToken, rotatedAt time.Time) error
+	RevokeRefreshToken(tokenHash string, revokedAt time.Time) error
+	RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error
+	RevokeUserRefreshTokens(userID int, revokedAt time.Time) error
+
+	CreateOneTimeToken(token OneTimeToken) error
+	UseOneTimeToken(id, purpose string, usedAt time.Time) (OneTimeToken, error)
+	DiscardOneTimeTokens(userID int, purpose string, at time.Time) error
+
+	CreateAuditEvent(event AuditEvent) (AuditEvent, error)
+
+	GetTOTP(userID int) (TOTP, error)
+	StartTOTPEnrollment(userID int, secret []byte) error
+	EnableTOTP(userID int, step int64, recoveryCodeHashes []string, enabledAt time.Time) error
+	UseTOTPStep(userID int, step int64) error
+	UseRecoveryCode(userID int, hash string, usedAt time.Time) error
+	RecordTOTPAttempt(userID int, at time.Time, maxFailures int, lockout time.Duration) error
+	DeleteTOTP(userID int) error
+
+	// Stats reports record totals as of now
+	Stats(now time.Time) (Stats, error)
+
+	// Reset removes all data from the store
+	Reset() error
+	Close() error
+}
+
+// Supported store drivers
+const (
+	DriverJSON   = "json"
+	DriverSQLite = "sqlite"
+)
+
+// Open opens a store at path using the named driver
+func Open(driver, path string) (Store, error) {
+	switch driver {
+	case DriverJSON:
+		db, err := NewDB(path)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case DriverSQLite:
+		db, err := NewSQLiteDB(path)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver: %q", driver)
+	}
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDrivers are the drivers every Store test runs against
+var testDrivers = []string{DriverJSON, DriverSQLite}
+
+// openTestStore opens a store of driver at a fresh path in a temporary directory
+func openTestStore(t *testing.T, driver string) (Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chirpy_database."+driver)
+	store, err := Open(driver, path)
+	if err != nil {
+		t.Fatalf("open %s store: %v", driver, err)
+	}
+	return store, path
+}
+
+// TestConcurrentWrites creates and updates users and chirps from many goroutines while others read,
+// then checks no ID was handed out twice and no write was lost, before and after reopening the store.
+// Run it with -race.
+func TestConcurrentWrites(t *testing.T) {
+	const (
+		workers        = 16
+		usersPerWorker = 5
+		chirpsPerUser  = 4
+	)
+
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			store, path := openTestStore(t, driver)
+
+			var (
+				mux      sync.Mutex
+				userIDs  = map[int]string{}
+				chirpIDs = map[int]int{}
+				errs     = make(chan error, workers*2)
+				writers  sync.WaitGroup
+				readers  sync.WaitGroup
+				done     = make(chan struct{})
+			)
+
+			// readers keep the read paths busy while the writes happen
+			for i := 0; i < 4; i++ {
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						_, err := store.GetChirps(ChirpQuery{Desc: true, Limit: 10})
+						if err == nil {
+							_, err = store.Stats(time.Now())
+						}
+						if err == nil {
+							_, err = store.GetUserByEmail("w0-u0@example.com")
+							if errors.Is(err, ErrNotExist) {
+								err = nil
+							}
+						}
+						if err != nil {
+							errs <- fmt.Errorf("read: %w", err)
+							return
+						}
+						// leave the writers room, the race detector makes tight read loops starve them
+						time.Sleep(time.Millisecond)
+					}
+				}()
+			}
+
+			for w := 0; w < workers; w++ {
+				writers.Add(1)
+				go func(w int) {
+					defer writers.Done()
+					for u := 0; u < usersPerWorker; u++ {
+						user, err := store.CreateUser(fmt.Sprintf("w%d-u%d@example.com", w, u), "hash")
+						if err != nil {
+							errs <- fmt.Errorf("create user: %w", err)
+							return
+						}
+						for c := 0; c < chirpsPerUser; c++ {
+							chirp, err := store.CreateChirp(Chirp{Body: fmt.Sprintf("chirp %d", c), AuthorID: user.ID})
+							if err != nil {
+								errs <- fmt.Errorf("create chirp: %w", err)
+								return
+							}
+							mux.Lock()
+							if _, exists := chirpIDs[chirp.ID]; exists {
+								errs <- fmt.Errorf("chirp ID %d handed out twice", chirp.ID)
+							}
+							chirpIDs[chirp.ID] = user.ID
+							mux.Unlock()
+						}
+
+						email := fmt.Sprintf("updated-w%d-u%d@example.com", w, u)
+						_, err = store.UpdateUser(user.ID, email, "new-hash")
+						if err != nil {
+							errs <- fmt.Errorf("update user: %w", err)
+							return
+						}
+						mux.Lock()
+						if _, exists := userIDs[user.ID]; exists {
+							errs <- fmt.Errorf("user ID %d handed out twice", user.ID)
+						}
+						userIDs[user.ID] = email
+						mux.Unlock()
+					}
+				}(w)
+			}
+
+			writers.Wait()
+			close(done)
+			readers.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+			if t.Failed() {
+				return
+			}
+
+			if len(userIDs) != workers*usersPerWorker {
+				t.Errorf("created %d users, want %d", len(userIDs), workers*usersPerWorker)
+			}
+			if len(chirpIDs) != workers*usersPerWorker*chirpsPerUser {
+				t.Errorf("created %d chirps, want %d", len(chirpIDs), workers*usersPerWorker*chirpsPerUser)
+			}
+
+			checkStored(t, store, userIDs, chirpIDs)
+			err := store.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// everything acknowledged has to survive a reopen
+			store, err = Open(driver, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			checkStored(t, store, userIDs, chirpIDs)
+		})
+	}
+}
+
+// checkStored checks store holds exactly the users and chirps the writers were told were created
+func checkStored(t *testing.T, store Store, userIDs map[int]string, chirpIDs map[int]int) {
+	t.Helper()
+
+	stats, err := store.Stats(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Users != len(userIDs) || stats.Chirps != len(chirpIDs) {
+		t.Errorf("store counts %d users and %d chirps, want %d and %d", stats.Users, stats.Chirps, len(userIDs), len(chirpIDs))
+	}
+
+	for id, email := range userIDs {
+		user, err := store.GetUser(id)
+		if err != nil {
+			t.Errorf("get user %d: %v", id, err)
+			continue
+		}
+		if user.Email != email || user.HashedPassword != "new-hash" {
+			t.Errorf("user %d is %q with password %q, want %q with the updated password", id, user.Email, user.HashedPassword, email)
+		}
+	}
+
+	chirps, err := store.GetChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != len(chirpIDs) {
+		t.Errorf("got %d chirps, want %d", len(chirps), len(chirpIDs))
+	}
+	for i, chirp := range chirps {
+		if i > 0 && chirp.ID <= chirps[i-1].ID {
+			t.Errorf("chirp %d listed after chirp %d", chirp.ID, chirps[i-1].ID)
+		}
+		if authorID, exists := chirpIDs[chirp.ID]; !exists || authorID != chirp.AuthorID {
+			t.Errorf("chirp %d by %d was not created by that author", chirp.ID, chirp.AuthorID)
+		}
+	}
+}
+
+// TestConcurrentDuplicateEmail registers the same email from many goroutines at once; exactly one may win
+func TestConcurrentDuplicateEmail(t *testing.T) {
+	const attempts = 16
+
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			store, _ := openTestStore(t, driver)
+			defer store.Close()
+
+			results := make(chan error, attempts)
+			start := make(chan struct{})
+			for i := 0; i < attempts; i++ {
+				go func() {
+					<-start
+					_, err := store.CreateUser("same@example.com", "hash")
+					results <- err
+				}()
+			}
+			close(start)
+
+			created := 0
+			for i := 0; i < attempts; i++ {
+				err := <-results
+				switch {
+				case err == nil:
+					created++
+				case !errors.Is(err, ErrAlreadyExists):
+					t.Errorf("create user: %v", err)
+				}
+			}
+			if created != 1 {
+				t.Errorf("%d users registered the same email, want 1", created)
+			}
+		})
+	}
+}
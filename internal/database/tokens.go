@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrTokenRevoked is returned when rotating a refresh token that has already been used or revoked
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// RefreshToken is the server-side record of an issued refresh token.
+// Only a hash of the token is stored. Every token rotated from the same
+// login shares a FamilyID so the whole chain can be revoked at once.
+type RefreshToken struct {
+	TokenHash  string    `json:"token_hash"`
+	UserID     int       `json:"user_id"`
+	FamilyID   string    `json:"family_id"`
+	IssuedAt   time.Time `json:"issued_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RevokedAt  time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy string    `json:"replaced_by,omitempty"`
+}
+
+// Revoked reports whether the token has been revoked or already rotated
+func (t RefreshToken) Revoked() bool {
+	return !t.RevokedAt.IsZero()
+}
+
+// CreateRefreshToken stores a newly issued refresh token
+func (db *DB) CreateRefreshToken(token RefreshToken) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, exists := db.data.RefreshTokens[token.TokenHash]; exists {
+		return fmt.Errorf("refresh token: %w", ErrAlreadyExists)
+	}
+
+	err := db.commit(putRecord(collectionRefreshTokens, token.TokenHash, token))
+	if err != nil {
+		slog.Error("Failed to write refresh token to database")
+		return err
+	}
+	return nil
+}
+
+// GetRefreshToken returns the refresh token with the given hash
+func (db *DB) GetRefreshToken(tokenHash string) (RefreshToken, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	token, exists := db.data.RefreshTokens[tokenHash]
+	if !exists {
+		return RefreshToken{}, ErrNotExist
+	}
+	return token, nil
+}
+
+// RotateRefreshToken marks the old token as used and stores its replacement in one step.
+// It returns ErrTokenRevoked if the old token was already used or revoked.
+func (db *DB) RotateRefreshToken(oldHash string, next RefreshToken, rotatedAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	old, exists := db.data.RefreshTokens[oldHash]
+	if !exists {
+		return ErrNotExist
+	}
+	if old.Revoked() {
+		return ErrTokenRevoked
+	}
+
+	old.RevokedAt = rotatedAt
+	old.ReplacedBy = next.TokenHash
+
+	err := db.commit(
+		putRecord(collectionRefreshTokens, oldHash, old),
+		putRecord(collectionRefreshTokens, next.TokenHash, next),
+	)
+	if err != nil {
+		slog.Error("Failed to write refresh token rotation to database")
+		return err
+	}
+	return nil
+}
+
+// RevokeRefreshToken revokes a single refresh token
+func (db *DB) RevokeRefreshToken(tokenHash string, revokedAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	token, exists := db.data.RefreshTokens[tokenHash]
+	if !exists {
+		return ErrNotExist
+	}
+	if token.Revoked() {
+		return nil
+	}
+
+	token.RevokedAt = revokedAt
+	err := db.commit(putRecord(collectionRefreshTokens, tokenHash, token))
+	if err != nil {
+		slog.Error("Failed to write revoked refresh token to database")
+		return err
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token rotated from the same login
+func (db *DB) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	records := []walRecord{}
+	for hash, token := range db.data.RefreshTokens {
+		if token.FamilyID != familyID || token.Revoked() {
+			continue
+		}
+		token.RevokedAt = revokedAt
+		records = append(records, putRecord(collectionRefreshTokens, hash, token))
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	err := db.commit(records...)
+	if err != nil {
+		slog.Error("Failed to write revoked refresh token family to database")
+		return err
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token issued to a user, signing them out everywhere
+func (db *DB) RevokeUserRefreshTokens(userID int, revokedAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	records := []walRecord{}
+	for hash, token := range db.data.RefreshTokens {
+		if token.UserID != userID || token.Revoked() {
+			continue
+		}
+		token.RevokedAt = revokedAt
+		records = append(records, putRecord(collectionRefreshTokens, hash, token))
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	err := db.commit(records...)
+	if err != nil {
+		slog.Error("Failed to write revoked user refresh tokens to database")
+		return err
+	}
+	return nil
+}
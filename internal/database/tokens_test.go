@@ -0,0 +1,68 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRotateRefreshTokenOnce rotates the same token from many goroutines at once.
+// Exactly one rotation may win; every other one must see the token as revoked.
+func TestRotateRefreshTokenOnce(t *testing.T) {
+	const rotations = 8
+	openTestStores(t, func(t *testing.T, store Store) {
+		users := mustCreateUsers(t, store, "a@example.com")
+		now := time.Now().UTC()
+		old := RefreshToken{TokenHash: "old", UserID: users[0], FamilyID: "family", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+		err := store.CreateRefreshToken(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errs := make(chan error, rotations)
+		var wg sync.WaitGroup
+		for i := range rotations {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				next := old
+				next.TokenHash = fmt.Sprintf("next-%d", i)
+				errs <- store.RotateRefreshToken(old.TokenHash, next, now)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		won := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				won++
+			case !errors.Is(err, ErrTokenRevoked):
+				t.Errorf("rotation failed: %v", err)
+			}
+		}
+		if won != 1 {
+			t.Fatalf("%d rotations won, want 1", won)
+		}
+
+		rotated, err := store.GetRefreshToken(old.TokenHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rotated.Revoked() || rotated.ReplacedBy == "" {
+			t.Fatalf("old token after rotation = %+v, want it revoked and replaced", rotated)
+		}
+		next, err := store.GetRefreshToken(rotated.ReplacedBy)
+		if err != nil || next.Revoked() || next.FamilyID != old.FamilyID {
+			t.Errorf("replacement = %+v, %v, want a live token in the same family", next, err)
+		}
+
+		err = store.RotateRefreshToken("missing", RefreshToken{TokenHash: "other", UserID: users[0], FamilyID: "x"}, now)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("rotating a missing token: %v, want ErrNotExist", err)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrLocked means too many wrong codes were tried recently
+var ErrLocked = errors.New("too many failed attempts")
+
+// TOTP is a user's authenticator app enrollment.
+// Secret is stored exactly as given; callers encrypt it first.
+type TOTP struct {
+	UserID int    `json:"user_id"`
+	Secret []byte `json:"secret"`
+	// EnabledAt is zero until the user confirms enrollment with a valid code
+	EnabledAt time.Time `json:"enabled_at,omitempty"`
+	// LastStep is the time step of the last accepted code, so no code works twice
+	LastStep int64 `json:"last_step"`
+	// FailedAttempts counts codes tried since the last accepted one and LastFailedAt is when the latest was tried
+	FailedAttempts int            `json:"failed_attempts"`
+	LastFailedAt   time.Time      `json:"last_failed_at,omitempty"`
+	RecoveryCodes  []RecoveryCode `json:"recovery_codes"`
+}
+
+// Enabled reports whether the user has finished enrolling
+func (t TOTP) Enabled() bool {
+	return !t.EnabledAt.IsZero()
+}
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the user has lost their device
+type RecoveryCode struct {
+	Hash   string    `json:"hash"`
+	UsedAt time.Time `json:"used_at,omitempty"`
+}
+
+// GetTOTP returns a user's TOTP enrollment, finished or not
+func (db *DB) GetTOTP(userID int) (TOTP, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	totp, exists := db.data.TOTP[userID]
+	if !exists {
+		return TOTP{}, ErrNotExist
+	}
+	return totp, nil
+}
+
+// StartTOTPEnrollment stores a new, not yet enabled, secret for a user, replacing any unfinished enrollment.
+// It returns ErrAlreadyExists if the user already has TOTP enabled.
+func (db *DB) StartTOTPEnrollment(userID int, secret []byte) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, exists := db.data.Users[userID]; !exists {
+		return fmt.Errorf("totp user ID %v: %w", userID, ErrNotExist)
+	}
+	if db.data.TOTP[userID].Enabled() {
+		return ErrAlreadyExists
+	}
+
+	err := db.commit(putRecord(collectionTOTP, userID, TOTP{UserID: userID, Secret: secret}))
+	if err != nil {
+		slog.Error("Failed to write totp enrollment to database")
+		return err
+	}
+	return nil
+}
+
+// EnableTOTP finishes a user's enrollment, accepting the code at step and storing the hashes of their recovery codes.
+// It returns ErrAlreadyExists if TOTP is already enabled.
+func (db *DB) EnableTOTP(userID int, step int64, recoveryCodeHashes []string, enabledAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	totp, exists := db.data.TOTP[userID]
+	if !exists {
+		return ErrNotExist
+	}
+	if totp.Enabled() {
+		return ErrAlreadyExists
+	}
+
+	totp.EnabledAt = enabledAt
+	totp.LastStep = step
+	totp.FailedAttempts = 0
+	totp.RecoveryCodes = make([]RecoveryCode, 0, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		totp.RecoveryCodes = append(totp.RecoveryCodes, RecoveryCode{Hash: hash})
+	}
+
+	err := db.commit(putRecord(collectionTOTP, userID, totp))
+	if err != nil {
+		slog.Error("Failed to write enabled totp to database")
+		return err
+	}
+	return nil
+}
+
+// UseTOTPStep accepts a code from the given time step and clears failed attempts.
+// It returns ErrTokenUsed if a code from that step or a later one was already accepted.
+func (db *DB) UseTOTPStep(userID int, step int64) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	totp, exists := db.data.TOTP[userID]
+	if !exists {
+		return ErrNotExist
+	}
+	if step <= totp.LastStep {
+		return ErrTokenUsed
+	}
+
+	totp.LastStep = step
+	totp.FailedAttempts = 0
+	err := db.commit(putRecord(collectionTOTP, userID, totp))
+	if err != nil {
+		slog.Error("Failed to write totp step to database")
+		return err
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code with the given hash as used and clears failed attempts.
+// It returns ErrNotExist if the user has no such code and ErrTokenUsed if it was used before.
+func (db *DB) UseRecoveryCode(userID int, hash string, usedAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	totp, exists := db.data.TOTP[userID]
+	if !exists {
+		return ErrNotExist
+	}
+
+	// copy so a failed commit leaves the stored slice untouched
+	codes := append([]RecoveryCode(nil), totp.RecoveryCodes...)
+	for i, code := range codes {
+		if code.Hash != hash {
+			continue
+		}
+		if !code.UsedAt.IsZero() {
+			return ErrTokenUsed
+		}
+		codes[i].UsedAt = usedAt
+		totp.RecoveryCodes = codes
+		totp.FailedAttempts = 0
+		err := db.commit(putRecord(collectionTOTP, userID, totp))
+		if err != nil {
+			slog.Error("Failed to write used recovery code to database")
+			return err
+		}
+		return nil
+	}
+	return ErrNotExist
+}
+
+// RecordTOTPAttempt counts a code before it is checked, so parallel guesses can't all get in under the limit.
+// Accepting the code clears the count again. It returns ErrLocked, counting nothing, when maxFailures codes
+// have been tried and lockout has not passed since the last of them.
+func (db *DB) RecordTOTPAttempt(userID int, at time.Time, maxFailures int, lockout time.Duration) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	totp, exists := db.data.TOTP[userID]
+	if !exists {
+		return ErrNotExist
+	}
+	if totp.FailedAttempts >= maxFailures && at.Before(totp.LastFailedAt.Add(lockout)) {
+		return ErrLocked
+	}
+
+	totp.FailedAttempts++
+	totp.LastFailedAt = at
+	err := db.commit(putRecord(collectionTOTP, userID, totp))
+	if err != nil {
+		slog.Error("Failed to write totp attempt to database")
+		return err
+	}
+	return nil
+}
+
+// DeleteTOTP removes a user's enrollment and recovery codes, turning two-factor authentication off
+func (db *DB) DeleteTOTP(userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, exists := db.data.TOTP[userID]; !exists {
+		return ErrNotExist
+	}
+
+	err := db.commit(deleteRecord(collectionTOTP, userID))
+	if err != nil {
+		slog.Error("Failed to delete totp from database")
+		return err
+	}
+	return nil
+}
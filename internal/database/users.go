@@ -2,7 +2,8 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 )
 
 type User struct {
@@ -10,23 +11,31 @@ type User struct {
 	ID             int    `json:"id"`
 	Email          string `json:"email"`
 	HashedPassword string `json:"hashed_password"`
+	IsChirpyRed    bool   `json:"is_chirpy_red"`
+	Role           Role   `json:"role"`
+	// Verified is set once the user follows the link emailed to Email
+	Verified bool `json:"verified"`
 }
 
 func (db *DB) CreateUser(email string, password string) (User, error) {
-	if _, exists := db.UserIDLookup(email); exists {
-		log.Printf("Email is already registered")
-		return User{}, fmt.Errorf("email already registered")
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	email = normalizeEmail(email)
+	if _, exists := db.userIDLookup(email); exists {
+		slog.Info("Email is already registered")
+		return User{}, fmt.Errorf("email already registered: %w", ErrAlreadyExists)
 	}
-	id := len(db.Data.Users) + 1
+	id, seq := db.nextID(collectionUsers)
 	user := User{
 		ID:             id,
 		Email:          email,
 		HashedPassword: password,
+		Role:           RoleUser,
 	}
-	db.Data.Users[id] = user
-	err := db.writeDB(db.Data)
+	err := db.commit(seq, putRecord(collectionUsers, id, user))
 	if err != nil {
-		log.Printf("Failed to write new user to database")
+		slog.Error("Failed to write new user to database")
 		return User{}, err
 	}
 	return user, nil
@@ -34,33 +43,162 @@ func (db *DB) CreateUser(email string, password string) (User, error) {
 }
 
 func (db *DB) UpdateUser(id int, email, password string) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
-	if _, exist := db.Data.Users[id]; !exist {
-		log.Printf("Attempted to update ser ID %v, which does not exist", id)
-		return User{}, fmt.Errorf("Attempted to update ser ID %v, which does not exist", id)
+	email = normalizeEmail(email)
+	updatedUser, exist := db.data.Users[id]
+	if !exist {
+		slog.Info("Attempted to update a user that does not exist", "user_id", id)
+		return User{}, fmt.Errorf("attempted to update user ID %v: %w", id, ErrNotExist)
 	}
 
-	updatedUser := User{
-		ID:             id,
-		Email:          email,
-		HashedPassword: password,
+	if otherID, exists := db.userIDLookup(email); exists && otherID != id {
+		slog.Info("Email is already registered")
+		return User{}, fmt.Errorf("email already registered: %w", ErrAlreadyExists)
 	}
 
-	db.Data.Users[id] = updatedUser
-	err := db.writeDB(db.Data)
+	if normalizeEmail(updatedUser.Email) != email {
+		// the new address has to be verified again
+		updatedUser.Verified = false
+	}
+	updatedUser.Email = email
+	updatedUser.HashedPassword = password
+
+	err := db.commit(putRecord(collectionUsers, id, updatedUser))
 	if err != nil {
-		log.Printf("Failed to write updated user to database")
+		slog.Error("Failed to write updated user to database")
 		return User{}, err
 	}
 	return updatedUser, nil
 
 }
 
-func (db *DB) UserIDLookup(email string) (int, bool) {
-	for id, val := range db.Data.Users {
-		if val.Email == email {
-			return id, true
+// SetChirpyRed sets whether a user has a Chirpy Red membership
+func (db *DB) SetChirpyRed(id int, isChirpyRed bool) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, exist := db.data.Users[id]
+	if !exist {
+		return User{}, fmt.Errorf("attempted to update user ID %v: %w", id, ErrNotExist)
+	}
+
+	user.IsChirpyRed = isChirpyRed
+	err := db.commit(putRecord(collectionUsers, id, user))
+	if err != nil {
+		slog.Error("Failed to write Chirpy Red membership to database")
+		return User{}, err
+	}
+	return user, nil
+}
+
+// SetUserVerified sets whether a user's email address has been verified
+func (db *DB) SetUserVerified(id int, verified bool) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, exist := db.data.Users[id]
+	if !exist {
+		return User{}, fmt.Errorf("attempted to update user ID %v: %w", id, ErrNotExist)
+	}
+
+	user.Verified = verified
+	err := db.commit(putRecord(collectionUsers, id, user))
+	if err != nil {
+		slog.Error("Failed to write user verification to database")
+		return User{}, err
+	}
+	return user, nil
+}
+
+// SetUserRole changes a user's role
+func (db *DB) SetUserRole(id int, role Role) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, exist := db.data.Users[id]
+	if !exist {
+		return User{}, fmt.Errorf("attempted to update user ID %v: %w", id, ErrNotExist)
+	}
+
+	user.Role = role
+	err := db.commit(putRecord(collectionUsers, id, user))
+	if err != nil {
+		slog.Error("Failed to write user role to database")
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetUser returns the user with the given ID
+func (db *DB) GetUser(id int) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	user, exists := db.data.Users[id]
+	if !exists {
+		return User{}, ErrNotExist
+	}
+	return user, nil
+}
+
+// GetUserByEmail returns the user registered with the given email
+func (db *DB) GetUserByEmail(email string) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	id, exists := db.userIDLookup(email)
+	if !exists {
+		return User{}, ErrNotExist
+	}
+	return db.data.Users[id], nil
+}
+
+// userIDLookup finds the ID registered to an email, ignoring case.
+// The caller must hold db.mux.
+func (db *DB) userIDLookup(email string) (int, bool) {
+	id, exists := db.data.index.usersByEmail[normalizeEmail(email)]
+	return id, exists
+}
+
+// normalizeEmail returns the form emails are stored and compared in.
+// Addresses are matched without regard to case, so Bob@example.com and bob@example.com are one user.
+func normalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+// lowercaseEmails works out the new email of each user registered before emails
+// were lowercased, given users in ID order. Where several users share an email
+// in different cases, one already stored lowercase keeps it, or else the lowest
+// ID gets it; the others keep theirs as it is and can't be found by email.
+func lowercaseEmails(users []User) map[int]string {
+	taken := make(map[string]bool, len(users))
+	for _, user := range users {
+		if user.Email == normalizeEmail(user.Email) {
+			taken[user.Email] = true
 		}
 	}
-	return 0, false
+	changed := make(map[int]string)
+	for _, user := range users {
+		email := normalizeEmail(user.Email)
+		if !taken[email] {
+			taken[email] = true
+			changed[user.ID] = email
+		}
+	}
+	return changed
+}
+
+// lowercaseEmails stores the emails of users registered before emails were lowercased in lowercase
+func (s *DBStructure) lowercaseEmails() {
+	users := make([]User, 0, len(s.Users))
+	for _, id := range sortedKeys(s.Users) {
+		users = append(users, s.Users[id])
+	}
+	for id, email := range lowercaseEmails(users) {
+		user := s.Users[id]
+		user.Email = email
+		s.Users[id] = user
+	}
 }
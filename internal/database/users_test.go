@@ -0,0 +1,150 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmailsIgnoreCase(t *testing.T) {
+	openTestStores(t, func(t *testing.T, store Store) {
+		user, err := store.CreateUser("Alice@Example.COM", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "alice@example.com" {
+			t.Errorf("stored email %q, want it lowercased", user.Email)
+		}
+
+		_, err = store.CreateUser("ALICE@example.com", "hash")
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("signup differing only in case: %v, want ErrAlreadyExists", err)
+		}
+
+		for _, email := range []string{"alice@example.com", "Alice@Example.COM", "ALICE@EXAMPLE.COM"} {
+			found, err := store.GetUserByEmail(email)
+			if err != nil || found.ID != user.ID {
+				t.Errorf("GetUserByEmail(%q) = %d, %v, want user %d", email, found.ID, err, user.ID)
+			}
+		}
+
+		other, err := store.CreateUser("bob@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.UpdateUser(other.ID, "Alice@example.com", "hash")
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("change to another user's email in another case: %v, want ErrAlreadyExists", err)
+		}
+
+		// changing only the case keeps the address verified
+		_, err = store.SetUserVerified(user.ID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err = store.UpdateUser(user.ID, "ALICE@EXAMPLE.COM", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "alice@example.com" || !user.Verified {
+			t.Errorf("after a case-only change: %q verified %v, want alice@example.com still verified", user.Email, user.Verified)
+		}
+
+		// a real change frees the old address and has to be verified again
+		user, err = store.UpdateUser(user.ID, "Alicia@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "alicia@example.com" || user.Verified {
+			t.Errorf("after an email change: %q verified %v, want alicia@example.com unverified", user.Email, user.Verified)
+		}
+		_, err = store.GetUserByEmail("alice@example.com")
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("old email after the change: %v, want ErrNotExist", err)
+		}
+		found, err := store.GetUserByEmail("ALICIA@example.com")
+		if err != nil || found.ID != user.ID {
+			t.Errorf("new email after the change = %d, %v, want user %d", found.ID, err, user.ID)
+		}
+		_, err = store.CreateUser("alice@example.com", "hash")
+		if err != nil {
+			t.Errorf("signup with the freed email: %v", err)
+		}
+	})
+}
+
+func TestEmailsLowercasedOnOpen(t *testing.T) {
+	// users registered before emails were lowercased, in ID order
+	legacy := []string{"Alice@Example.com", "BOB@example.com", "bob@example.com", "Carol@example.com", "CAROL@example.com"}
+	want := map[string]int{
+		"alice@example.com": 1,
+		// one user already has the lowercase email, so it stays theirs
+		"bob@example.com": 3,
+		// otherwise the lowest ID gets it
+		"carol@example.com": 4,
+	}
+
+	writeLegacy := map[string]func(t *testing.T, path string){
+		DriverJSON: func(t *testing.T, path string) {
+			users := make(map[int]User)
+			for i, email := range legacy {
+				users[i+1] = User{ID: i + 1, Email: email, HashedPassword: "hash"}
+			}
+			data, err := json.Marshal(map[string]any{"chirps": map[int]Chirp{}, "users": users})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(path, data, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+		DriverSQLite: func(t *testing.T, path string) {
+			db, err := NewSQLiteDB(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for _, email := range legacy {
+				_, err = db.conn.Exec("INSERT INTO users (email, hashed_password) VALUES (?, 'hash')", email)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// as if the lowercasing migration hadn't run yet
+			_, err = db.conn.Exec("PRAGMA user_version = 14")
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chirpy_database."+driver)
+			writeLegacy[driver](t, path)
+
+			store, err := Open(driver, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+
+			for email, id := range want {
+				user, err := store.GetUserByEmail(email)
+				if err != nil || user.ID != id || user.Email != email {
+					t.Errorf("GetUserByEmail(%q) = %d %q, %v, want user %d", email, user.ID, user.Email, err, id)
+				}
+			}
+			// the losers keep their email as it was
+			for id, email := range map[int]string{2: "BOB@example.com", 5: "CAROL@example.com"} {
+				user, err := store.GetUser(id)
+				if err != nil || user.Email != email {
+					t.Errorf("user %d has %q, %v, want %q", id, user.Email, err, email)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// names of the DBStructure collections as they appear in the write-ahead log
+const (
+	collectionChirps        = "chirps"
+	collectionUsers         = "users"
+	collectionRefreshTokens = "refresh_tokens"
+	collectionOneTimeTokens = "one_time_tokens"
+	collectionAuditEvents   = "audit_events"
+	collectionTOTP          = "totp"
+	collectionFollows       = "follows"
+	collectionLikes         = "likes"
+	collectionSequences     = "sequences"
+)
+
+// walRecord sets (or, with a nil Value, deletes) one key of one collection
+type walRecord struct {
+	Collection string `json:"c"`
+	Key        string `json:"k"`
+	Value      any    `json:"v,omitempty"`
+}
+
+// walEntry is one line of the log; all of its records are applied together
+type walEntry struct {
+	Records []walRecord `json:"records"`
+}
+
+// rawWALEntry is how a walEntry is read back from the log
+type rawWALEntry struct {
+	Records []struct {
+		Collection string          `json:"c"`
+		Key        string          `json:"k"`
+		Value      json.RawMessage `json:"v"`
+	} `json:"records"`
+}
+
+func putRecord(collection string, key any, value any) walRecord {
+	return walRecord{Collection: collection, Key: fmt.Sprint(key), Value: value}
+}
+
+func deleteRecord(collection string, key any) walRecord {
+	return walRecord{Collection: collection, Key: fmt.Sprint(key)}
+}
+
+// walPath returns the path of the log that sits next to the snapshot
+func (db *DB) walPath() string {
+	return db.path + ".wal"
+}
+
+// openWAL opens the log for appending, creating it if needed
+func (db *DB) openWAL(flag int) error {
+	wal, err := os.OpenFile(db.walPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND|flag, 0600)
+	if err != nil {
+		slog.Error("Failed to open write-ahead log")
+		return err
+	}
+	info, err := wal.Stat()
+	if err != nil {
+		wal.Close()
+		return err
+	}
+	db.wal = wal
+	db.walSize = info.Size()
+	return syncDir(db.path)
+}
+
+// replayWAL applies every complete entry in the log to the in-memory data
+func (db *DB) replayWAL() error {
+	file, err := os.Open(db.walPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		slog.Error("Failed to open write-ahead log for replay")
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	replayed := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				// a crash mid-append leaves a torn final line; it was never acknowledged
+				slog.Warn("Discarding incomplete write-ahead log entry")
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = db.data.applyEntry(line)
+		if err != nil {
+			slog.Error("Failed to replay write-ahead log entry", "entry", replayed+1, "err", err)
+			return err
+		}
+		replayed++
+	}
+
+	if replayed > 0 {
+		slog.Info("Replayed write-ahead log", "entries", replayed)
+	}
+	return nil
+}
+
+// commit durably appends the records to the log and then applies them in memory.
+// The caller must hold db.mux for writing.
+func (db *DB) commit(records ...walRecord) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if db.wal == nil {
+		// an earlier compaction couldn't start a new log; everything is in memory, so try again
+		err := db.compact()
+		if err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(walEntry{Records: records})
+	if err != nil {
+		slog.Error("Failed to marshal write-ahead log entry")
+		return err
+	}
+	line = append(line, '\n')
+
+	_, err = db.wal.Write(line)
+	if err == nil {
+		err = db.wal.Sync()
+	}
+	if err != nil {
+		slog.Error("Failed to append to write-ahead log")
+		// drop whatever part of the entry made it to disk so later entries stay readable
+		db.wal.Truncate(db.walSize)
+		return err
+	}
+	db.walSize += int64(len(line))
+	db.walEntries++
+
+	err = db.data.applyEntry(line)
+	if err != nil {
+		return err
+	}
+
+	if db.walEntries >= compactThreshold {
+		err = db.compact()
+		if err != nil {
+			// the entry is already durable in the log, compaction will be retried
+			slog.Error("Failed to compact database", "err", err)
+		}
+	}
+	return nil
+}
+
+// compact writes a fresh snapshot and empties the log.
+// If the new log can't be opened db.wal is left nil and the next commit compacts again.
+// The caller must hold db.mux for writing.
+func (db *DB) compact() error {
+	err := db.writeDB(db.data)
+	if err != nil {
+		return err
+	}
+
+	if db.wal != nil {
+		db.wal.Close()
+		db.wal = nil
+	}
+	err = db.openWAL(os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	db.walEntries = 0
+	return nil
+}
+
+// applyEntry decodes one log line and applies its records
+func (s *DBStructure) applyEntry(line []byte) error {
+	entry := rawWALEntry{}
+	err := json.Unmarshal(line, &entry)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range entry.Records {
+		switch rec.Collection {
+		case collectionChirps:
+			err = s.applyChirp(rec.Key, rec.Value)
+		case collectionUsers:
+			err = s.applyUser(rec.Key, rec.Value)
+		case collectionRefreshTokens:
+			err = applyRecord(s.RefreshTokens, rec.Key, rec.Value)
+		case collectionOneTimeTokens:
+			err = applyRecord(s.OneTimeTokens, rec.Key, rec.Value)
+		case collectionAuditEvents:
+			err = applyIntKey(s.AuditEvents, rec.Key, rec.Value)
+		case collectionTOTP:
+			err = applyIntKey(s.TOTP, rec.Key, rec.Value)
+		case collectionFollows:
+			err = s.applyFollow(rec.Key, rec.Value)
+		case collectionLikes:
+			err = s.applyLike(rec.Key, rec.Value)
+		case collectionSequences:
+			err = applyRecord(s.Sequences, rec.Key, rec.Value)
+		default:
+			err = fmt.Errorf("unknown collection %q in write-ahead log", rec.Collection)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyIntKey[V any](m map[int]V, key string, value json.RawMessage) error {
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return err
+	}
+	return applyRecord(m, id, value)
+}
+
+func applyRecord[K comparable, V any](m map[K]V, key K, value json.RawMessage) error {
+	if value == nil {
+		delete(m, key)
+		return nil
+	}
+	var v V
+	err := json.Unmarshal(value, &v)
+	if err != nil {
+		return err
+	}
+	m[key] = v
+	return nil
+}
@@ -0,0 +1,374 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB opens a JSON database at a fresh path in a temporary directory
+func openTestDB(t *testing.T) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chirpy_database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	return db, path
+}
+
+// crash drops db without folding the log into the snapshot, as if the process died
+func crash(t *testing.T, db *DB) {
+	t.Helper()
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.wal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.wal = nil
+	db.closed = true
+}
+
+// reopen opens the database at path again, failing the test if it can't
+func reopen(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// walLines returns the entries in the log at path
+func walLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestReplayAfterCrash(t *testing.T) {
+	db, path := openTestDB(t)
+
+	user, err := db.CreateUser("a@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := db.CreateChirp(Chirp{Body: "kept", AuthorID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := db.CreateChirp(Chirp{Body: "deleted", AuthorID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DeleteChirp(deleted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.UpdateUser(user.ID, "b@example.com", "new-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if walLines(t, path) == 0 {
+		t.Fatal("writes did not reach the write-ahead log")
+	}
+	crash(t, db)
+
+	db = reopen(t, path)
+	got, err := db.GetUser(user.ID)
+	if err != nil || got.Email != "b@example.com" || got.HashedPassword != "new-hash" {
+		t.Errorf("user after replay = %+v, %v, want the update applied", got, err)
+	}
+	chirp, err := db.GetChirp(kept.ID)
+	if err != nil || chirp.Body != "kept" {
+		t.Errorf("chirp %d after replay = %+v, %v", kept.ID, chirp, err)
+	}
+	_, err = db.GetChirp(deleted.ID)
+	if err == nil {
+		t.Errorf("deleted chirp %d came back after replay", deleted.ID)
+	}
+	chirps, err := db.GetChirps(ChirpQuery{AuthorID: user.ID})
+	if err != nil || len(chirps) != 1 {
+		t.Errorf("author index after replay lists %d chirps, %v, want 1", len(chirps), err)
+	}
+
+	// replay folds the log into the snapshot and starts a new one
+	if lines := walLines(t, path); lines != 0 {
+		t.Errorf("log holds %d entries after reopening, want 0", lines)
+	}
+
+	// IDs are never handed out twice, not even the deleted chirp's
+	next, err := db.CreateChirp(Chirp{Body: "next", AuthorID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != deleted.ID+1 {
+		t.Errorf("chirp after replay got ID %d, want %d", next.ID, deleted.ID+1)
+	}
+}
+
+func TestReplayTornFinalLine(t *testing.T) {
+	db, path := openTestDB(t)
+	_, err := db.CreateUser("a@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(t, db)
+
+	// a crash part way through an append leaves an entry without its newline
+	wal, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wal.WriteString(`{"records":[{"c":"users","k":"2","v":{"id":2,"email":"torn@exa`)
+	wal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db = reopen(t, path)
+	_, err = db.GetUserByEmail("a@example.com")
+	if err != nil {
+		t.Errorf("complete entry was lost: %v", err)
+	}
+	_, err = db.GetUser(2)
+	if err == nil {
+		t.Error("torn entry was applied")
+	}
+
+	// later writes must not be glued onto the torn line
+	user, err := db.CreateUser("c@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(t, db)
+	db = reopen(t, path)
+	_, err = db.GetUser(user.ID)
+	if err != nil {
+		t.Errorf("write after the torn entry was lost: %v", err)
+	}
+}
+
+func TestReplayRejectsCorruptEntry(t *testing.T) {
+	db, path := openTestDB(t)
+	_, err := db.CreateUser("a@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(t, db)
+
+	// a complete line that doesn't parse was acknowledged, so it must not be skipped silently
+	wal, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wal.WriteString("{not json}\n")
+	wal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewDB(path)
+	if err == nil {
+		t.Fatal("opened a database whose log has a corrupt entry")
+	}
+}
+
+func TestTruncatedSnapshot(t *testing.T) {
+	t.Run("left behind by writeDB", func(t *testing.T) {
+		db, path := openTestDB(t)
+		_, err := db.CreateUser("a@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a crash while writing the next snapshot leaves a partial temp file; the rename never happened
+		err = os.WriteFile(path+".tmp-123", []byte(`{"chirps":{},"users":{"1":{"id`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db = reopen(t, path)
+		_, err = db.GetUserByEmail("a@example.com")
+		if err != nil {
+			t.Errorf("snapshot was lost: %v", err)
+		}
+		_, err = os.Stat(path + ".tmp-123")
+		if !os.IsNotExist(err) {
+			t.Errorf("partial temp snapshot was not removed: %v", err)
+		}
+	})
+
+	t.Run("in place", func(t *testing.T) {
+		db, path := openTestDB(t)
+		_, err := db.CreateUser("a@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		truncated := data[:len(data)/2]
+		err = os.WriteFile(path, truncated, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// refusing to open is the only safe answer, starting empty would overwrite what is left
+		_, err = NewDB(path)
+		if err == nil {
+			t.Fatal("opened a truncated snapshot")
+		}
+		after, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(after, truncated) {
+			t.Error("opening a truncated snapshot changed it")
+		}
+	})
+}
+
+func TestCompactionThenReopen(t *testing.T) {
+	db, path := openTestDB(t)
+
+	total := compactThreshold + 10
+	for i := 0; i < total; i++ {
+		_, err := db.CreateUser(fmt.Sprintf("user%d@example.com", i), "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the threshold folded the first entries into the snapshot
+	if lines := walLines(t, path); lines != total-compactThreshold {
+		t.Errorf("log holds %d entries, want %d", lines, total-compactThreshold)
+	}
+	snapshot, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(snapshot), fmt.Sprintf("user%d@example.com", compactThreshold-1)) {
+		t.Error("snapshot is missing users written before compaction")
+	}
+
+	crash(t, db)
+	db = reopen(t, path)
+
+	for i := 0; i < total; i++ {
+		user, err := db.GetUserByEmail(fmt.Sprintf("user%d@example.com", i))
+		if err != nil {
+			t.Fatalf("user %d lost after compaction and reopen: %v", i, err)
+		}
+		if user.ID != i+1 {
+			t.Errorf("user %d has ID %d, want %d", i, user.ID, i+1)
+		}
+	}
+
+	user, err := db.CreateUser("next@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != total+1 {
+		t.Errorf("user after reopen got ID %d, want %d", user.ID, total+1)
+	}
+}
+
+func TestCompactionRecoversFromLogReopenFailure(t *testing.T) {
+	db, path := openTestDB(t)
+	_, err := db.CreateUser("a@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a directory where the log should be makes opening a new log fail after the snapshot is written
+	err = os.Remove(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(path+".wal", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.mux.Lock()
+	err = db.compact()
+	stale := db.wal
+	db.mux.Unlock()
+	if err == nil {
+		t.Fatal("compaction succeeded without a log")
+	}
+	if stale != nil {
+		t.Fatal("compaction left the closed log in place")
+	}
+
+	// writes are refused, not lost into a closed file, while the log can't be opened
+	_, err = db.CreateUser("refused@example.com", "hash")
+	if err == nil {
+		t.Fatal("write accepted without a log")
+	}
+	_, err = db.GetUserByEmail("refused@example.com")
+	if err == nil {
+		t.Error("refused write was applied in memory")
+	}
+
+	// once the log can be opened again the next write starts it
+	err = os.Remove(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("b@example.com", "hash")
+	if err != nil {
+		t.Fatalf("write after the log became available: %v", err)
+	}
+	if walLines(t, path) != 1 {
+		t.Errorf("new log holds %d entries, want 1", walLines(t, path))
+	}
+
+	crash(t, db)
+	db = reopen(t, path)
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		_, err = db.GetUserByEmail(email)
+		if err != nil {
+			t.Errorf("%s lost: %v", email, err)
+		}
+	}
+	if got, err := db.GetUser(user.ID); err != nil || got.Email != "b@example.com" {
+		t.Errorf("user %d = %+v, %v", user.ID, got, err)
+	}
+}
+
+func TestClosedDBRefusesWrites(t *testing.T) {
+	db, _ := openTestDB(t)
+	err := db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateUser("a@example.com", "hash")
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("write after close: %v, want ErrClosed", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
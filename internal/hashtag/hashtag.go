@@ -0,0 +1,118 @@
+// Package hashtag finds #hashtags in chirp bodies and normalizes them for matching.
+// A hashtag is '#' (or the full-width '＃') followed by letters, marks, digits and
+// connector punctuation such as '_', in any script, with at least one letter.
+package hashtag
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Hashtag is one hashtag found in a text. Offsets cover the '#' and are half-open.
+type Hashtag struct {
+	// Text is the hashtag as written, without the '#'
+	Text string
+	// Tag is Text normalized with Normalize
+	Tag string
+	// ByteStart and ByteEnd are offsets into the UTF-8 text
+	ByteStart int
+	ByteEnd   int
+	// RuneStart and RuneEnd count code points
+	RuneStart int
+	RuneEnd   int
+}
+
+// isMarker reports whether r starts a hashtag
+func isMarker(r rune) bool {
+	return r == '#' || r == '＃'
+}
+
+// isTagRune reports whether r can be part of a hashtag
+func isTagRune(r rune) bool {
+	return unicode.In(r, unicode.L, unicode.M, unicode.Nd, unicode.Pc)
+}
+
+// Extract returns the hashtags in text in the order they appear.
+// A marker only starts a hashtag at the start of text or after a rune that can't be part of one,
+// so "a#b" and "##b" hold no hashtags.
+func Extract(text string) []Hashtag {
+	hashtags := []Hashtag{}
+	var previous rune
+	runeIndex := 0
+	for byteIndex := 0; byteIndex < len(text); {
+		r, size := utf8.DecodeRuneInString(text[byteIndex:])
+		if !isMarker(r) || isTagRune(previous) || isMarker(previous) {
+			previous = r
+			byteIndex += size
+			runeIndex++
+			continue
+		}
+
+		// read the tag after the marker
+		end, runeEnd := byteIndex+size, runeIndex+1
+		for end < len(text) {
+			next, nextSize := utf8.DecodeRuneInString(text[end:])
+			if !isTagRune(next) {
+				break
+			}
+			end += nextSize
+			runeEnd++
+		}
+
+		tag := text[byteIndex+size : end]
+		if Valid(tag) {
+			hashtags = append(hashtags, Hashtag{
+				Text:      tag,
+				Tag:       Normalize(tag),
+				ByteStart: byteIndex,
+				ByteEnd:   end,
+				RuneStart: runeIndex,
+				RuneEnd:   runeEnd,
+			})
+		}
+
+		// carry on after the tag, whose last rune is what the next marker is checked against
+		previous, _ = utf8.DecodeLastRuneInString(text[:end])
+		byteIndex, runeIndex = end, runeEnd
+	}
+	return hashtags
+}
+
+// Tags returns the distinct normalized tags in text, sorted
+func Tags(text string) []string {
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, hashtag := range Extract(text) {
+		if !seen[hashtag.Tag] {
+			seen[hashtag.Tag] = true
+			tags = append(tags, hashtag.Tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Valid reports whether tag, without its '#', is a hashtag
+func Valid(tag string) bool {
+	hasLetter := false
+	for _, r := range tag {
+		if !isTagRune(r) {
+			return false
+		}
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		}
+	}
+	return hasLetter
+}
+
+// Normalize returns the form tags are matched in, so "#Café", "#CAFÉ" and "#ｃａｆｅ́" match.
+// It approximates Unicode's NFKC_Casefold: compatibility composed, case folded, composed again.
+func Normalize(tag string) string {
+	// a cases.Caser keeps state between calls, so each call needs its own
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(tag)))
+}
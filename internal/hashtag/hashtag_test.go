@@ -0,0 +1,170 @@
+package hashtag
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Hashtag
+	}{
+		{"none", "just words", []Hashtag{}},
+		{"two", "hello #Go and #go!", []Hashtag{
+			{Text: "Go", Tag: "go", ByteStart: 6, ByteEnd: 9, RuneStart: 6, RuneEnd: 9},
+			{Text: "go", Tag: "go", ByteStart: 14, ByteEnd: 17, RuneStart: 14, RuneEnd: 17},
+		}},
+		{"multibyte text before and inside", "Café ☕ #Café #日本語", []Hashtag{
+			{Text: "Café", Tag: "café", ByteStart: 10, ByteEnd: 16, RuneStart: 7, RuneEnd: 12},
+			{Text: "日本語", Tag: "日本語", ByteStart: 17, ByteEnd: 27, RuneStart: 13, RuneEnd: 17},
+		}},
+		{"full-width marker and letters", "＃ｃａｆｅ tea", []Hashtag{
+			{Text: "ｃａｆｅ", Tag: "cafe", ByteStart: 0, ByteEnd: 15, RuneStart: 0, RuneEnd: 5},
+		}},
+		{"combining mark", "#cafe\u0301 au lait", []Hashtag{
+			{Text: "cafe\u0301", Tag: "café", ByteStart: 0, ByteEnd: 7, RuneStart: 0, RuneEnd: 6},
+		}},
+		{"ends at emoji", "#go🚀 now", []Hashtag{
+			{Text: "go", Tag: "go", ByteStart: 0, ByteEnd: 3, RuneStart: 0, RuneEnd: 3},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Extract(tt.text)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Extract(%q) =\n%+v\nwant\n%+v", tt.text, got, tt.want)
+			}
+			// the offsets have to cut the hashtag, marker included, out of the text
+			runes := []rune(tt.text)
+			for _, hashtag := range got {
+				if byteSlice := tt.text[hashtag.ByteStart:hashtag.ByteEnd]; !isMarkerOf(byteSlice, hashtag.Text) {
+					t.Errorf("bytes %d:%d of %q are %q", hashtag.ByteStart, hashtag.ByteEnd, tt.text, byteSlice)
+				}
+				if runeSlice := string(runes[hashtag.RuneStart:hashtag.RuneEnd]); !isMarkerOf(runeSlice, hashtag.Text) {
+					t.Errorf("runes %d:%d of %q are %q", hashtag.RuneStart, hashtag.RuneEnd, tt.text, runeSlice)
+				}
+			}
+		})
+	}
+}
+
+// isMarkerOf reports whether s is text with a hashtag marker in front
+func isMarkerOf(s, text string) bool {
+	return s == "#"+text || s == "＃"+text
+}
+
+// TestExtractBoundaries checks where hashtags start and stop around punctuation and other words
+func TestExtractBoundaries(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"#tag", []string{"tag"}},
+		{"line one\n#tag", []string{"tag"}},
+		{"end of sentence #tag.", []string{"tag"}},
+		{"(#tag)", []string{"tag"}},
+		{"#tag, #other; #third:", []string{"tag", "other", "third"}},
+		{"#tag's", []string{"tag"}},
+		{"#with-dash", []string{"with"}},
+		{"#snake_case", []string{"snake_case"}},
+		{"#1st", []string{"1st"}},
+		{"#a #b", []string{"a", "b"}},
+		// a marker straight after a word or another marker is not a hashtag
+		{"a#b", []string{}},
+		{"email#tag", []string{}},
+		{"##b", []string{}},
+		{"#a#b", []string{"a"}},
+		// the tag needs a letter
+		{"#1", []string{}},
+		{"#2024", []string{}},
+		{"#_", []string{}},
+		{"#", []string{}},
+		{"# tag", []string{}},
+		{"#!", []string{}},
+		// punctuation that isn't a connector ends the tag rather than joining it
+		{"#rock&roll", []string{"rock"}},
+		{"#c++", []string{"c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := []string{}
+			for _, hashtag := range Extract(tt.text) {
+				got = append(got, hashtag.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Extract(%q) found %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"go", "go"},
+		{"Go", "go"},
+		{"Café", "café"},
+		{"CAFÉ", "café"},
+		{"cafe\u0301", "café"},
+		{"ｃａｆｅ\u0301", "café"},
+		{"Straße", "strasse"},
+		{"ﬁle", "file"},
+		{"ΣΟΦΟΣ", "σοφοσ"},
+		{"日本語", "日本語"},
+		{"ｶﾀｶﾅ", "カタカナ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got := Normalize(tt.tag); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.tag, got, tt.want)
+			}
+			if again := Normalize(Normalize(tt.tag)); again != Normalize(tt.tag) {
+				t.Errorf("Normalize(%q) is not stable: %q", tt.tag, again)
+			}
+		})
+	}
+
+	// accents still count
+	if Normalize("cafe") == Normalize("café") {
+		t.Error("cafe and café normalize the same")
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{"go", true},
+		{"1st", true},
+		{"snake_case", true},
+		{"日本語", true},
+		{"cafe\u0301", true},
+		{"", false},
+		{"1", false},
+		{"_", false},
+		{"a-b", false},
+		{"a b", false},
+		{"#go", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.tag); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	got := Tags("#B #a #A #b #CAFÉ #café")
+	want := []string{"a", "b", "café"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Tags = %q, want %q", got, want)
+	}
+	if got := Tags("no tags"); got == nil || len(got) != 0 {
+		t.Errorf("Tags without hashtags = %#v, want an empty slice", got)
+	}
+}
@@ -0,0 +1,188 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Supported mailer drivers
+const (
+	// DriverLog writes messages to the log instead of sending them, for local development
+	DriverLog = "log"
+	// DriverFile writes each message to its own .eml file, for development and tests
+	DriverFile = "file"
+	// DriverSMTP sends messages through an SMTP server
+	DriverSMTP = "smtp"
+	// DriverNone refuses to send messages, for servers without email
+	DriverNone = "none"
+)
+
+// ErrDisabled is returned by DisabledMailer for every message
+var ErrDisabled = errors.New("mailer is disabled")
+
+// Config selects and configures a mailer
+type Config struct {
+	Driver string
+	From   string
+	// Dir is where DriverFile writes messages
+	Dir          string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// New returns the mailer selected by config.Driver
+func New(config Config) (Mailer, error) {
+	switch config.Driver {
+	case DriverLog:
+		return LogMailer{From: config.From}, nil
+	case DriverFile:
+		if config.Dir == "" {
+			return nil, errors.New("file mailer needs a directory")
+		}
+		return FileMailer{From: config.From, Dir: config.Dir}, nil
+	case DriverSMTP:
+		if config.SMTPHost == "" {
+			return nil, errors.New("smtp mailer needs a host")
+		}
+		return SMTPMailer{
+			From:     config.From,
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+		}, nil
+	case DriverNone:
+		return DisabledMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer driver %q, must be log, file, smtp or none", config.Driver)
+	}
+}
+
+// LogMailer logs messages instead of sending them. Only the headers are logged,
+// as bodies hold one-time tokens; use FileMailer to read them.
+type LogMailer struct {
+	From string
+}
+
+func (m LogMailer) Send(msg Message) error {
+	err := checkHeaders(m.From, msg)
+	if err != nil {
+		return err
+	}
+	slog.Info("Email not sent, mailer is set to log", "from", m.From, "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+// DisabledMailer refuses every message
+type DisabledMailer struct{}
+
+func (DisabledMailer) Send(msg Message) error {
+	return ErrDisabled
+}
+
+// FileMailer writes every message to a new file in Dir
+type FileMailer struct {
+	From string
+	Dir  string
+}
+
+func (m FileMailer) Send(msg Message) error {
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+	// names sort in the order messages were sent
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0600)
+}
+
+// SMTPMailer sends messages through an SMTP server.
+// PLAIN authentication is used when Username is set, which net/smtp
+// only allows over TLS or to localhost.
+type SMTPMailer struct {
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func (m SMTPMailer) Send(msg Message) error {
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, m.From, []string{msg.To}, data)
+}
+
+// checkHeaders rejects values that would let a caller inject extra headers
+func checkHeaders(from string, msg Message) error {
+	for _, value := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.New("email headers must not contain line breaks")
+		}
+	}
+	return nil
+}
+
+// format renders msg as an RFC 5322 message
+func format(from string, msg Message) ([]byte, error) {
+	err := checkHeaders(from, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
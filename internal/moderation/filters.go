@@ -0,0 +1,127 @@
+package moderation
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// leetspeak maps the look-alike characters people use to dodge word lists
+var leetspeak = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+}
+
+// normalize reduces a word to the form word lists are matched in:
+// compatibility-decomposed with accents stripped, lower case, and leetspeak undone.
+// "Kérfuffle", "ＫＥＲＦＵＦＦＬＥ" and "k3rfuffl3" all normalize to "kerfuffle".
+func normalize(word string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if plain, ok := leetspeak[r]; ok {
+			r = plain
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// isWordRune reports whether r can be part of a word.
+// Leetspeak symbols count so "k3rfuffl3" and "$harbert" stay in one piece.
+func isWordRune(r rune) bool {
+	if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+		return true
+	}
+	return r == '@' || r == '$'
+}
+
+// words splits body into words, ignoring surrounding punctuation,
+// so "Kerfuffle!" and "(sharbert)" are found as "Kerfuffle" and "sharbert"
+func words(body string) []Span {
+	spans := []Span{}
+	start := -1
+	for i, r := range body {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			spans = append(spans, Span{Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, Span{Start: start, End: len(body)})
+	}
+	return spans
+}
+
+// WordListFilter matches whole words from a list after normalizing both sides
+type WordListFilter struct {
+	name   string
+	action Action
+	words  map[string]bool
+}
+
+// NewWordListFilter returns a filter matching any of words
+func NewWordListFilter(name string, action Action, words []string) *WordListFilter {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word != "" {
+			set[normalize(word)] = true
+		}
+	}
+	return &WordListFilter{name: name, action: action, words: set}
+}
+
+func (f *WordListFilter) Name() string   { return f.name }
+func (f *WordListFilter) Action() Action { return f.action }
+
+func (f *WordListFilter) Find(body string) []Span {
+	matches := []Span{}
+	for _, span := range words(body) {
+		if f.words[normalize(body[span.Start:span.End])] {
+			matches = append(matches, span)
+		}
+	}
+	return matches
+}
+
+// RegexFilter matches a regular expression against the raw chirp body
+type RegexFilter struct {
+	name    string
+	action  Action
+	pattern *regexp.Regexp
+}
+
+// NewRegexFilter returns a filter matching pattern
+func NewRegexFilter(name string, action Action, pattern *regexp.Regexp) *RegexFilter {
+	return &RegexFilter{name: name, action: action, pattern: pattern}
+}
+
+func (f *RegexFilter) Name() string   { return f.name }
+func (f *RegexFilter) Action() Action { return f.action }
+
+func (f *RegexFilter) Find(body string) []Span {
+	matches := []Span{}
+	for _, loc := range f.pattern.FindAllStringIndex(body, -1) {
+		if loc[1] > loc[0] {
+			matches = append(matches, Span{Start: loc[0], End: loc[1]})
+		}
+	}
+	return matches
+}
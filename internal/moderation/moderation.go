@@ -0,0 +1,118 @@
+// Package moderation checks chirp bodies against an ordered chain of filters.
+// Each filter finds the parts of a body it objects to and its action decides
+// whether those parts are masked, the whole chirp is rejected, or the chirp
+// is kept but flagged for a moderator to review.
+package moderation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Action is what happens to a chirp when a filter matches it
+type Action string
+
+const (
+	ActionMask   Action = "mask"
+	ActionReject Action = "reject"
+	ActionFlag   Action = "flag"
+)
+
+// mask replaces every masked match, matching what the original profanity filter did
+const mask = "****"
+
+// Span is a byte range [Start, End) of a chirp body
+type Span struct {
+	Start int
+	End   int
+}
+
+// Filter finds the parts of a chirp body that break one rule
+type Filter interface {
+	Name() string
+	Action() Action
+	Find(body string) []Span
+}
+
+// Result is the outcome of moderating one chirp body
+type Result struct {
+	// Body is the chirp body with every masked match replaced
+	Body string
+	// Rejected is set when a reject rule matched; RejectedBy names it
+	Rejected   bool
+	RejectedBy string
+	// Flagged is set when a flag rule matched; FlaggedBy names every one that did
+	Flagged   bool
+	FlaggedBy []string
+}
+
+// Chain applies filters in order.
+// Later filters see the body as left by earlier mask filters.
+type Chain struct {
+	filters []Filter
+}
+
+// NewChain returns a chain that applies filters in the order given
+func NewChain(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+// Apply runs body through every filter in the chain.
+// It stops at the first reject rule that matches.
+func (c *Chain) Apply(body string) Result {
+	result := Result{Body: body}
+
+	for _, filter := range c.filters {
+		spans := filter.Find(result.Body)
+		if len(spans) == 0 {
+			continue
+		}
+
+		switch filter.Action() {
+		case ActionReject:
+			result.Rejected = true
+			result.RejectedBy = filter.Name()
+			return result
+		case ActionFlag:
+			result.Flagged = true
+			result.FlaggedBy = append(result.FlaggedBy, filter.Name())
+		case ActionMask:
+			result.Body = maskSpans(result.Body, spans)
+		}
+	}
+
+	return result
+}
+
+// maskSpans replaces each span of body with the mask, merging any that overlap
+func maskSpans(body string, spans []Span) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		if span.End <= last {
+			continue
+		}
+		if span.Start < last {
+			span.Start = last
+		} else {
+			b.WriteString(body[last:span.Start])
+			b.WriteString(mask)
+		}
+		last = span.End
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+// parseAction validates an action name from a rules file
+func parseAction(action string) (Action, error) {
+	switch Action(action) {
+	case ActionMask, ActionReject, ActionFlag:
+		return Action(action), nil
+	default:
+		return "", fmt.Errorf("unknown action %q, must be mask, reject or flag", action)
+	}
+}
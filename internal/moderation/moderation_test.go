@@ -0,0 +1,207 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"kerfuffle", "kerfuffle"},
+		{"Kerfuffle", "kerfuffle"},
+		{"KERFUFFLE", "kerfuffle"},
+		{"Kérfuffle", "kerfuffle"},
+		{"kérfuffle", "kerfuffle"},
+		{"ＫＥＲＦＵＦＦＬＥ", "kerfuffle"},
+		{"k3rfuffl3", "kerfuffle"},
+		{"$h4rb3rt", "sharbert"},
+		{"f0rn@x", "fornax"},
+		{"5h@rb3r7", "sharbert"},
+		{"ﬁle", "file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			if got := normalize(tt.word); got != tt.want {
+				t.Errorf("normalize(%q) = %q, want %q", tt.word, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordListFilterFind(t *testing.T) {
+	filter := NewWordListFilter("profanity", ActionMask, defaultWords)
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"a perfectly nice chirp", []string{}},
+		{"what a kerfuffle", []string{"kerfuffle"}},
+		{"Kerfuffle!", []string{"Kerfuffle"}},
+		{"(sharbert), fornax.", []string{"sharbert", "fornax"}},
+		{"\"k3rfuffl3\"", []string{"k3rfuffl3"}},
+		{"$harbert?", []string{"$harbert"}},
+		{"ＫＥＲＦＵＦＦＬＥ", []string{"ＫＥＲＦＵＦＦＬＥ"}},
+		{"Kérfuffle", []string{"Kérfuffle"}},
+		// only whole words match
+		{"kerfuffles and sharberts", []string{}},
+		{"kerfufflekerfuffle", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			got := []string{}
+			for _, span := range filter.Find(tt.body) {
+				got = append(got, tt.body[span.Start:span.End])
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Find(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainApply(t *testing.T) {
+	chain := NewChain(
+		NewWordListFilter("profanity", ActionMask, defaultWords),
+		NewRegexFilter("links", ActionReject, regexp.MustCompile(`https?://\S+`)),
+		NewWordListFilter("spam", ActionFlag, []string{"giveaway"}),
+		NewRegexFilter("shouting", ActionFlag, regexp.MustCompile(`[A-Z]{5,}`)),
+	)
+	tests := []struct {
+		name string
+		body string
+		want Result
+	}{
+		{"clean", "hello there", Result{Body: "hello there"}},
+		{"mask", "Kerfuffle! What a k3rfuffl3.", Result{Body: "****! What a ****."}},
+		{"mask every match", "sharbert fornax sharbert", Result{Body: "**** **** ****"}},
+		{"reject", "see https://example.com", Result{Body: "see https://example.com", Rejected: true, RejectedBy: "links"}},
+		{"flag", "big giveaway", Result{Body: "big giveaway", Flagged: true, FlaggedBy: []string{"spam"}}},
+		{"flag by every matching rule", "GIVEAWAY now", Result{Body: "GIVEAWAY now", Flagged: true, FlaggedBy: []string{"spam", "shouting"}}},
+		{"mask then flag", "fornax giveaway", Result{Body: "**** giveaway", Flagged: true, FlaggedBy: []string{"spam"}}},
+		// later rules see the masked body, so a masked word can't trip them
+		{"flag sees masked body", "KERFUFFLE", Result{Body: "****"}},
+		// a reject stops the chain and reports the body as it stood
+		{"reject stops the chain", "sharbert giveaway http://x", Result{Body: "**** giveaway http://x", Rejected: true, RejectedBy: "links"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chain.Apply(tt.body)
+			if got.Body != tt.want.Body || got.Rejected != tt.want.Rejected || got.RejectedBy != tt.want.RejectedBy ||
+				got.Flagged != tt.want.Flagged || !slices.Equal(got.FlaggedBy, tt.want.FlaggedBy) {
+				t.Errorf("Apply(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskSpansMergesOverlaps(t *testing.T) {
+	got := maskSpans("abcdefghij", []Span{{6, 8}, {1, 4}, {2, 5}, {2, 3}})
+	if want := "a****f****ij"; got != want {
+		t.Errorf("maskSpans = %q, want %q", got, want)
+	}
+}
+
+// writeRules writes a rules file into dir and returns its path
+func writeRules(t *testing.T, dir, rules string) string {
+	t.Helper()
+	path := filepath.Join(dir, "moderation.json")
+	err := os.WriteFile(path, []byte(rules), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewModeratorDefaults(t *testing.T) {
+	m, err := NewModerator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Moderate("Kerfuffle!").Body; got != "****!" {
+		t.Errorf("default rules left %q", got)
+	}
+}
+
+func TestModeratorRulesFile(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "words.txt"), []byte("# banned words\nwidget\n\n  gadget  \n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeRules(t, dir, `{"rules": [
+		{"name": "words", "type": "wordlist", "action": "mask", "file": "words.txt", "words": ["gizmo"]},
+		{"name": "numbers", "type": "regex", "action": "reject", "pattern": "\\d{6}"}
+	]}`)
+
+	m, err := NewModerator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Moderate("widget, gadget and gizmo").Body; got != "****, **** and ****" {
+		t.Errorf("word list rule left %q", got)
+	}
+	if got := m.Moderate("call 555123"); !got.Rejected || got.RejectedBy != "numbers" {
+		t.Errorf("regex rule gave %+v, want a rejection by numbers", got)
+	}
+}
+
+func TestModeratorReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeRules(t, dir, `{"rules": [{"type": "wordlist", "action": "mask", "words": ["widget"]}]}`)
+	m, err := NewModerator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a good file replaces the rules
+	writeRules(t, dir, `{"rules": [{"type": "wordlist", "action": "reject", "words": ["gadget"]}]}`)
+	err = m.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Moderate("widget gadget"); !got.Rejected || got.RejectedBy != "rule 1" {
+		t.Errorf("after reload got %+v, want a rejection by rule 1", got)
+	}
+
+	// a bad file is reported and the rules loaded before stay in effect
+	bad := []struct {
+		name  string
+		rules string
+	}{
+		{"not json", `{"rules": [`},
+		{"unknown action", `{"rules": [{"type": "wordlist", "action": "delete", "words": ["widget"]}]}`},
+		{"unknown type", `{"rules": [{"type": "glob", "action": "mask", "words": ["widget"]}]}`},
+		{"no words", `{"rules": [{"type": "wordlist", "action": "mask"}]}`},
+		{"missing word file", `{"rules": [{"type": "wordlist", "action": "mask", "file": "missing.txt"}]}`},
+		{"bad pattern", `{"rules": [{"type": "regex", "action": "mask", "pattern": "("}]}`},
+	}
+	for _, tt := range bad {
+		t.Run(tt.name, func(t *testing.T) {
+			writeRules(t, dir, tt.rules)
+			if err := m.Reload(); err == nil {
+				t.Fatal("reload accepted a bad rules file")
+			}
+			if got := m.Moderate("widget gadget"); !got.Rejected || got.RejectedBy != "rule 1" {
+				t.Errorf("after a failed reload got %+v, want the previous rules", got)
+			}
+		})
+	}
+
+	// a missing file is an error too
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Error("reload accepted a missing rules file")
+	}
+	if got := m.Moderate("gadget"); !got.Rejected {
+		t.Errorf("after reloading a missing file got %+v, want the previous rules", got)
+	}
+}
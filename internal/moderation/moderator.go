@@ -0,0 +1,155 @@
+package moderation
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultWords is the word list used when no rules file is configured
+var defaultWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// RuleConfig is one rule in a rules file
+type RuleConfig struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Action string `json:"action"`
+	// Words and File supply the words of a wordlist rule; File is one word per line, # starts a comment
+	Words []string `json:"words"`
+	File  string   `json:"file"`
+	// Pattern is the regular expression of a regex rule
+	Pattern string `json:"pattern"`
+}
+
+// Config is the contents of a rules file, rules run in the order listed
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// Moderator holds the current filter chain and swaps in a new one on Reload,
+// so rules can change while chirps are being moderated
+type Moderator struct {
+	path  string
+	chain atomic.Pointer[Chain]
+}
+
+// NewModerator loads the rules file at path.
+// With an empty path the built-in word list is masked, as chirpy always has.
+func NewModerator(path string) (*Moderator, error) {
+	m := &Moderator{path: path}
+	err := m.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload re-reads the rules file. On error the current rules stay in place.
+func (m *Moderator) Reload() error {
+	if m.path == "" {
+		m.chain.Store(NewChain(NewWordListFilter("profanity", ActionMask, defaultWords)))
+		return nil
+	}
+
+	chain, err := loadChain(m.path)
+	if err != nil {
+		return fmt.Errorf("loading moderation rules from %s: %w", m.path, err)
+	}
+	m.chain.Store(chain)
+	return nil
+}
+
+// Moderate runs body through the current rules
+func (m *Moderator) Moderate(body string) Result {
+	return m.chain.Load().Apply(body)
+}
+
+// loadChain builds a chain from the rules file at path
+func loadChain(path string) (*Chain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make([]Filter, 0, len(config.Rules))
+	for i, rule := range config.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		filter, err := buildFilter(rule, filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Name, err)
+		}
+		filters = append(filters, filter)
+	}
+
+	return NewChain(filters...), nil
+}
+
+// buildFilter turns one rule into a filter; relative word list files are resolved from dir
+func buildFilter(rule RuleConfig, dir string) (Filter, error) {
+	action, err := parseAction(rule.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	switch rule.Type {
+	case "wordlist":
+		words := rule.Words
+		if rule.File != "" {
+			path := rule.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			fileWords, err := readWordList(path)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, fileWords...)
+		}
+		if len(words) == 0 {
+			return nil, errors.New("wordlist rule has no words")
+		}
+		return NewWordListFilter(rule.Name, action, words), nil
+	case "regex":
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return NewRegexFilter(rule.Name, action, pattern), nil
+	default:
+		return nil, fmt.Errorf("unknown rule type %q, must be wordlist or regex", rule.Type)
+	}
+}
+
+// readWordList reads one word per line, skipping blank lines and # comments
+func readWordList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,112 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 4226 and RFC 6238 test vectors
+var rfcSecret = []byte("12345678901234567890")
+
+// TestCodeHOTPVectors checks Code against RFC 4226 appendix D, where the step is the HOTP counter
+func TestCodeHOTPVectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := Code(rfcSecret, int64(counter)); got != code {
+			t.Errorf("Code(counter %d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// TestCodeTOTPVectors checks the SHA1 vectors of RFC 6238 appendix B.
+// The RFC gives eight digits; six digit codes are their last six.
+func TestCodeTOTPVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		step int64
+		code string
+	}{
+		{59, 0x1, "94287082"},
+		{1111111109, 0x23523EC, "07081804"},
+		{1111111111, 0x23523ED, "14050471"},
+		{1234567890, 0x273EF07, "89005924"},
+		{2000000000, 0x3F940AA, "69279037"},
+		{20000000000, 0x27BC86AA, "65353130"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		if got := Step(now); got != tt.step {
+			t.Errorf("Step(%d) = %#x, want %#x", tt.unix, got, tt.step)
+		}
+		want := tt.code[len(tt.code)-Digits:]
+		if got := Code(rfcSecret, Step(now)); got != want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, want)
+		}
+		step, ok := Validate(rfcSecret, want, now)
+		if !ok || step != tt.step {
+			t.Errorf("Validate(%s) at %d = %#x, %v, want %#x, true", want, tt.unix, step, ok, tt.step)
+		}
+	}
+}
+
+// TestValidateSkew checks a code is accepted exactly Skew steps either side of its own
+func TestValidateSkew(t *testing.T) {
+	const step = 0x23523ED
+	code := Code(rfcSecret, step)
+	period := int64(Period.Seconds())
+	start := step * period
+
+	tests := []struct {
+		name string
+		unix int64
+		ok   bool
+	}{
+		{"before the earliest accepted step", start - period - 1, false},
+		{"first second of the earliest accepted step", start - period, true},
+		{"first second of its own step", start, true},
+		{"last second of its own step", start + period - 1, true},
+		{"last second of the latest accepted step", start + 2*period - 1, true},
+		{"after the latest accepted step", start + 2*period, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, code, time.Unix(tt.unix, 0))
+			if ok != tt.ok {
+				t.Fatalf("Validate at %d = %v, want %v", tt.unix, ok, tt.ok)
+			}
+			// the step returned is the code's, not the current one, so replays are caught
+			if ok && got != step {
+				t.Errorf("Validate at %d returned step %#x, want %#x", tt.unix, got, step)
+			}
+		})
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code := Code(rfcSecret, Step(now))
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"empty", ""},
+		{"too short", code[1:]},
+		{"too long", code + "0"},
+		{"eight digit form", "14050471"},
+		{"padded", " " + code[1:]},
+		{"another secret's code", Code([]byte("another secret value"), Step(now))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(rfcSecret, tt.code, now); ok {
+				t.Errorf("Validate(%q) accepted", tt.code)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	if got, want := Encode(rfcSecret), "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"; got != want {
+		t.Errorf("Encode = %s, want %s", got, want)
+	}
+}
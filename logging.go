@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// log output formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// redacted replaces the value of any attribute that could hold a secret
+const redacted = "[REDACTED]"
+
+// sensitiveLogKeys are attribute keys whose values are never written to the log
+var sensitiveLogKeys = map[string]bool{
+	"authorization": true,
+	"password":      true,
+	"token":         true,
+	"refresh_token": true,
+	"access_token":  true,
+	"api_key":       true,
+	"secret":        true,
+	"jwt_secret":    true,
+	"polka_key":     true,
+	"smtp_password": true,
+	"totp_key":      true,
+	"metrics_token": true,
+	"mfa_token":     true,
+	"code":          true,
+}
+
+// newLogger returns a logger that writes format ("text" or "json") records at level or above to w
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redactAttr}
+	switch format {
+	case logFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, must be text or json", format)
+}
+
+// redactAttr hides the values of attributes listed in sensitiveLogKeys
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveLogKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// requestLog is the per-request logging state kept in the request context.
+// It is shared by pointer so the user ID added by the auth middleware reaches the access log.
+type requestLog struct {
+	logger *slog.Logger
+}
+
+const contextKeyRequestLog contextKey = "requestLog"
+
+// maxRequestIDLength bounds request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// middlewareRequestID gives every request an ID, reusing an inbound X-Request-ID when it is sane.
+// The ID is echoed in the response and attached to every log record of the request.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			// crypto/rand does not fail on supported platforms
+			id, _ = randomHex(16)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		entry := &requestLog{logger: slog.Default().With("request_id", id)}
+		ctx := context.WithValue(r.Context(), contextKeyRequestLog, entry)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID reports whether a client supplied ID is safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isAlnum && !strings.ContainsRune("-_.:", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// middlewareAccessLog writes one log record per request once it has been served.
+// It must run inside a chi router, after middlewareRequestID, so the route pattern is known.
+func middlewareAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			// the handler wrote nothing, which net/http sends as 200
+			status = http.StatusOK
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			attrs = append(attrs, slog.String("route", rctx.RoutePattern()))
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		requestLogger(r).LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+func requestLogFromContext(ctx context.Context) *requestLog {
+	entry, _ := ctx.Value(contextKeyRequestLog).(*requestLog)
+	return entry
+}
+
+// requestLogger returns the logger for r, which tags records with the request ID
+// and, once authenticated, the user ID
+func requestLogger(r *http.Request) *slog.Logger {
+	entry := requestLogFromContext(r.Context())
+	if entry == nil {
+		return slog.Default()
+	}
+	return entry.logger
+}
+
+// setRequestUserID records the authenticated user on the request's log state
+func setRequestUserID(ctx context.Context, userID int) {
+	entry := requestLogFromContext(ctx)
+	if entry == nil {
+		return
+	}
+	entry.logger = entry.logger.With("user_id", userID)
+}
@@ -1,102 +1,344 @@
 package main
 
 import (
+	"context"
+	"crypto/cipher"
 	"errors"
 	"flag"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/mailer"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/moderation"
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
 )
 
 type apiConfig struct {
-	fileserverHits int
-	chirpyDatabase database.DB
+	fileserverHits atomic.Int64
+	chirpyDatabase database.Store
 	jwtSecret      string
-	revokedTokens  map[string]time.Time
+	polkaKey       string
+	metricsToken   string
+	moderator      *moderation.Moderator
+	metrics        *serverMetrics
+	maxChirpLength int
+	adminEmails    []string
+	// email
+	mailer               mailer.Mailer
+	publicURL            string
+	requireVerifiedEmail bool
+	// totpCipher encrypts TOTP secrets before they are stored
+	totpCipher cipher.AEAD
+	// token lifetimes
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	// background tracks work that outlives its request, so shutdown can wait for it
+	background sync.WaitGroup
 }
 
+// goBackground runs fn after the current request is answered
+func (cfg *apiConfig) goBackground(fn func()) {
+	cfg.background.Add(1)
+	go func() {
+		defer cfg.background.Done()
+		fn()
+	}()
+}
+
+// waitBackground waits for work started by goBackground until ctx is done
+func (cfg *apiConfig) waitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		cfg.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// process exit codes
+const (
+	exitOK = 0
+	// exitError means chirpy could not start or failed while running
+	exitError = 1
+	// exitShutdownIncomplete means requests were cut off or the database did not close cleanly
+	exitShutdownIncomplete = 2
+)
+
 func main() {
+	os.Exit(run())
+}
 
-	const port = "8080"
-	const filePathRoot = "."
-	const dbFilePath = "./chirpy_database.json"
+// run starts the server and blocks until it has shut down, returning the process exit code
+func run() int {
 
 	godotenv.Load()
-	jwtSecret := os.Getenv("JWT_SECRET")
 
-	dbg := flag.Bool("debug", false, "Enable debug mode")
-	flag.Parse()
+	config, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		slog.Error("Invalid configuration", "err", err)
+		return exitError
+	}
+
+	logger, err := newLogger(os.Stderr, config.Log.Format, config.Log.Level)
+	if err != nil {
+		slog.Error("Invalid log configuration", "err", err)
+		return exitError
+	}
+	// also routes anything still written through the log package
+	slog.SetDefault(logger)
+
+	chirpyDB, err := database.Open(config.Database.Driver, config.Database.Path)
+	if err != nil {
+		slog.Error("Failed to init database", "driver", config.Database.Driver, "path", config.Database.Path, "err", err)
+		return exitError
+	}
+	// on a clean shutdown the database is closed below, this only covers early returns
+	dbClosed := false
+	defer func() {
+		if !dbClosed {
+			chirpyDB.Close()
+		}
+	}()
+
+	if config.Server.Debug {
+		// start every debug session with an empty database
+		err := chirpyDB.Reset()
+		if err != nil {
+			slog.Error("Failed to reset database", "err", err)
+			return exitError
+		}
+	}
+
+	moderator, err := moderation.NewModerator(config.Chirps.ModerationRules)
+	if err != nil {
+		slog.Error("Failed to load moderation rules", "path", config.Chirps.ModerationRules, "err", err)
+		return exitError
+	}
 
-	if *dbg {
-		_, err := os.Stat(dbFilePath)
-		if !errors.Is(err, os.ErrNotExist) {
-			err := os.Remove(dbFilePath)
+	// reload moderation rules on SIGHUP without restarting
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			err := moderator.Reload()
 			if err != nil {
-				log.Fatal(err)
+				slog.Error("Failed to reload moderation rules", "err", err)
+				continue
 			}
+			slog.Info("Reloaded moderation rules")
 		}
+	}()
+
+	chirpyMailer, err := mailer.New(config.mailerConfig())
+	if err != nil {
+		slog.Error("Failed to set up mailer", "err", err)
+		return exitError
+	}
+	switch mailerConfig := config.mailerConfig(); mailerConfig.Driver {
+	case mailer.DriverNone:
+		slog.Warn("Mail driver is none, verification and password reset emails will not be sent")
+	case mailer.DriverFile:
+		slog.Info("Writing emails to files", "dir", mailerConfig.Dir)
+	}
+
+	totpKey, err := config.totpKey()
+	if err != nil {
+		slog.Error("Invalid TOTP key", "err", err)
+		return exitError
+	}
+	totpCipher, err := newTOTPCipher(totpKey)
+	if err != nil {
+		slog.Error("Failed to set up TOTP encryption", "err", err)
+		return exitError
+	}
+	if config.Auth.TOTPKey == "" {
+		slog.Warn("CHIRPY_TOTP_KEY is not set, TOTP secrets are encrypted with a key derived from JWT_SECRET")
+	}
+
+	metrics := newServerMetrics()
+
+	apiCfg := &apiConfig{
+		chirpyDatabase:       metrics.instrumentStore(chirpyDB),
+		metrics:              metrics,
+		jwtSecret:            config.Auth.JWTSecret,
+		polkaKey:             config.Auth.PolkaKey,
+		metricsToken:         config.Auth.MetricsToken,
+		moderator:            moderator,
+		maxChirpLength:       config.Chirps.MaxLength,
+		adminEmails:          config.Auth.AdminEmails,
+		mailer:               chirpyMailer,
+		publicURL:            config.Server.PublicURL,
+		requireVerifiedEmail: config.Auth.RequireVerifiedEmail,
+		totpCipher:           totpCipher,
+		accessTokenTTL:       config.Auth.AccessTokenTTL,
+		refreshTokenTTL:      config.Auth.RefreshTokenTTL,
 	}
 
-	chirpyDB, err := database.NewDB(dbFilePath)
+	err = apiCfg.promoteAdmins()
 	if err != nil {
-		log.Fatal("Failed to init database")
+		slog.Error("Failed to promote configured admins", "err", err)
+		return exitError
+	}
+
+	if apiCfg.polkaKey == "" {
+		slog.Warn("POLKA_KEY is not set, Polka webhooks will be rejected")
 	}
 
-	apiCfg := apiConfig{
-		fileserverHits: 0,
-		chirpyDatabase: *chirpyDB,
-		jwtSecret:      jwtSecret,
-		revokedTokens:  make(map[string]time.Time),
+	if apiCfg.metricsToken == "" {
+		slog.Warn("CHIRPY_METRICS_TOKEN is not set, Prometheus scrapes will be rejected")
 	}
 
 	// File server routing /app and /app/*
 
 	router := chi.NewRouter()
 
-	fileServerHandler := apiCfg.middlewareMetricsIncrementer(http.StripPrefix("/app", http.FileServer(http.Dir(filePathRoot))))
+	router.Use(middlewareRequestID, middlewareAccessLog, apiCfg.metrics.middleware)
+
+	fileServerHandler := apiCfg.middlewareMetricsIncrementer(http.StripPrefix("/app", http.FileServer(http.Dir(config.Server.FileRoot))))
 
 	router.Handle("/app", fileServerHandler)
 
 	router.Handle("/app/*", fileServerHandler)
 
+	// Prometheus scrape endpoint, which needs its own token as scrapers can't log in
+
+	router.With(apiCfg.middlewareRequireMetricsToken).Handle("/metrics", apiCfg.metrics.handler())
+
 	// API routing
 
 	rApi := chi.NewRouter()
 
 	rApi.Get("/healthz", healthHandler)
 
-	rApi.Get("/reset", apiCfg.metricsReset)
+	rApi.Post("/users", apiCfg.postUserHandler)
 
-	rApi.Get("/chirps", apiCfg.getChirpsHandler)
+	rApi.Get("/users/{userID}/followers", apiCfg.getFollowersHandler)
 
-	rApi.Get("/chirps/{chirpID}", apiCfg.getChirpHandler)
+	rApi.Get("/users/{userID}/following", apiCfg.getFollowingHandler)
 
-	rApi.Post("/chirps", apiCfg.postChirpHandler)
+	// Chirp listings are public, and say which chirps the caller likes when they send an access token
 
-	rApi.Post("/users", apiCfg.postUserHandler)
+	rApi.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareOptionalAuth(issuerAccess))
+
+		r.Get("/chirps", apiCfg.getChirpsHandler)
 
-	rApi.Put("/users", apiCfg.putUserHandler)
+		r.Get("/chirps/{chirpID}", apiCfg.getChirpHandler)
+
+		r.Get("/chirps/{chirpID}/thread", apiCfg.getChirpThreadHandler)
+
+		r.Get("/users/{userID}/likes", apiCfg.getUserLikesHandler)
+
+		r.Get("/tags/{tag}/chirps", apiCfg.getTagChirpsHandler)
+	})
 
 	rApi.Post("/login", apiCfg.postLoginHandler)
 
+	rApi.Post("/login/mfa", apiCfg.postLoginMFAHandler)
+
+	rApi.Get("/users/verify", apiCfg.getVerifyEmailHandler)
+
+	rApi.Post("/password-reset/request", apiCfg.postPasswordResetRequestHandler)
+
+	rApi.Post("/password-reset/confirm", apiCfg.postPasswordResetConfirmHandler)
+
+	// Routes that require an access token
+
+	rApi.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequireAuth(issuerAccess))
+
+		r.With(apiCfg.middlewareRequireVerifiedEmail).Post("/chirps", apiCfg.postChirpHandler)
+
+		r.With(apiCfg.middlewareRequireVerifiedEmail).Post("/chirps/{chirpID}/rechirps", apiCfg.postRechirpHandler)
+
+		r.Delete("/chirps/{chirpID}", apiCfg.deleteChirpHandler)
+
+		r.Post("/chirps/{chirpID}/likes", apiCfg.postLikeHandler)
+
+		r.Delete("/chirps/{chirpID}/likes", apiCfg.deleteLikeHandler)
+
+		r.Put("/users", apiCfg.putUserHandler)
+
+		r.Post("/users/verify/resend", apiCfg.postResendVerificationHandler)
+
+		r.Post("/users/totp", apiCfg.postTOTPHandler)
+
+		r.Post("/users/totp/confirm", apiCfg.postTOTPConfirmHandler)
+
+		r.Delete("/users/totp", apiCfg.deleteTOTPHandler)
+
+		r.Post("/users/{userID}/follow", apiCfg.postFollowHandler)
+
+		r.Delete("/users/{userID}/follow", apiCfg.deleteFollowHandler)
+
+		r.Get("/timeline", apiCfg.getTimelineHandler)
+	})
+
+	// Refresh tokens are opaque and checked against the database by their handlers
+
 	rApi.Post("/refresh", apiCfg.postRefreshHandler)
 
 	rApi.Post("/revoke", apiCfg.postRevokeTokenHandler)
 
+	rApi.Post("/polka/webhooks", apiCfg.postPolkaWebhookHandler)
+
 	router.Mount("/api", rApi)
 
 	// Admin routing
 
 	rAdmin := chi.NewRouter()
 
-	rAdmin.Get("/metrics", apiCfg.metricsHandler)
+	rAdmin.Use(apiCfg.middlewareRequireAuth(issuerAccess))
+
+	// Moderators work the review queue
+
+	rAdmin.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequireRole(database.RoleModerator))
+
+		r.Get("/moderation/flagged", apiCfg.getFlaggedChirpsHandler)
+
+		r.Post("/moderation/flagged/{chirpID}/approve", apiCfg.approveFlaggedChirpHandler)
+	})
 
-	rAdmin.Get("/dbreset", apiCfg.chirpyDatabase.DatabaseResetHandler)
+	// Everything else is for admins, and actions that change server state need a confirmation token
+
+	rAdmin.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRequireRole(database.RoleAdmin))
+
+		r.Get("/metrics", apiCfg.metricsHandler)
+
+		r.Post("/confirmations", apiCfg.postConfirmationHandler)
+
+		r.With(apiCfg.middlewareRequireConfirmation(actionReloadModeration)).Post("/moderation/reload", apiCfg.moderationReloadHandler)
+
+		r.With(apiCfg.middlewareRequireConfirmation(actionSetRole)).Put("/users/{userID}/role", apiCfg.putUserRoleHandler)
+
+		// Resets are only for local development
+
+		if config.Server.Debug {
+			r.With(apiCfg.middlewareRequireConfirmation(actionResetDatabase)).Post("/dbreset", apiCfg.databaseResetHandler)
+
+			r.With(apiCfg.middlewareRequireConfirmation(actionResetMetrics)).Post("/reset", apiCfg.metricsReset)
+		}
+	})
 
 	router.Mount("/admin", rAdmin)
 
@@ -105,10 +347,57 @@ func main() {
 	corsMux := middlewareCors(router)
 
 	srv := &http.Server{
-		Addr:    "localhost:" + port,
+		Addr:    net.JoinHostPort(config.Server.Host, strconv.Itoa(config.Server.Port)),
 		Handler: corsMux,
 	}
 
-	log.Printf("Serving on port: %s\n", port)
-	log.Fatal(srv.ListenAndServe())
+	// stop accepting connections on SIGINT or SIGTERM and let in-flight requests finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Serving", "addr", srv.Addr)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		slog.Error("Server failed", "err", err)
+		return exitError
+	case <-ctx.Done():
+	}
+
+	// a second signal kills the process immediately
+	stop()
+
+	exitCode := exitOK
+
+	slog.Info("Shutting down, waiting for in-flight requests", "timeout", config.Server.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Error("Requests did not finish in time, closing remaining connections", "err", err)
+		srv.Close()
+		exitCode = exitShutdownIncomplete
+	}
+
+	err = apiCfg.waitBackground(shutdownCtx)
+	if err != nil {
+		slog.Error("Background work did not finish in time", "err", err)
+		exitCode = exitShutdownIncomplete
+	}
+
+	// no request can reach the database now, so flush it to disk
+	dbClosed = true
+	err = chirpyDB.Close()
+	if err != nil {
+		slog.Error("Failed to close database cleanly", "err", err)
+		exitCode = exitShutdownIncomplete
+	}
+
+	slog.Info("Shutdown complete")
+	return exitCode
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/mailer"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/moderation"
+	"github.com/go-chi/chi/v5"
+)
+
+// testDrivers are the database drivers handler tests run against
+var testDrivers = []string{database.DriverJSON, database.DriverSQLite}
+
+// newTestConfig returns an apiConfig backed by a fresh database of driver in a temporary directory
+func newTestConfig(t *testing.T, driver string) *apiConfig {
+	t.Helper()
+
+	db, err := database.Open(driver, filepath.Join(t.TempDir(), "chirpy_database."+driver))
+	if err != nil {
+		t.Fatalf("open %s database: %v", driver, err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	moderator, err := moderation.NewModerator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totpCipher, err := newTOTPCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &apiConfig{
+		chirpyDatabase:  db,
+		jwtSecret:       "test-secret",
+		maxChirpLength:  defaultMaxChirpLength,
+		moderator:       moderator,
+		totpCipher:      totpCipher,
+		accessTokenTTL:  time.Hour,
+		refreshTokenTTL: 24 * time.Hour,
+	}
+}
+
+// callRoute is callHandler for a handler routed at pattern, so it can read its URL parameters
+func callRoute(t *testing.T, pattern string, handler http.HandlerFunc, method, path string, userID int, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	router := chi.NewRouter()
+	router.Method(method, pattern, handler)
+	return callHandler(t, router.ServeHTTP, method, path, userID, body)
+}
+
+// recordingMailer keeps the messages it is asked to send
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// emailedToken matches the one-time token in a message, a JWT
+var emailedToken = regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]+`)
+
+// lastToken returns the token in the last message sent to to
+func (m *recordingMailer) lastToken(t *testing.T, to string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To != to {
+			continue
+		}
+		token := emailedToken.FindString(m.messages[i].Body)
+		if token == "" {
+			t.Fatalf("no token in message to %s: %q", to, m.messages[i].Body)
+		}
+		return token
+	}
+	t.Fatalf("no message was sent to %s", to)
+	return ""
+}
@@ -3,16 +3,25 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"time"
 )
 
 func (cfg *apiConfig) middlewareMetricsIncrementer(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits++
+		cfg.fileserverHits.Add(1)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// metricsHandler is the human-friendly view of the server's metrics; /metrics has the full set
 func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	stats, err := cfg.chirpyDatabase.Stats(time.Now().UTC())
+	if err != nil {
+		requestLogger(req).Error("Failed to get database stats", "err", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get database stats")
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 
 	w.WriteHeader(http.StatusOK)
@@ -23,16 +32,17 @@ func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, req *http.Request) {
 	<body>
 		<h1>Welcome, Chirpy Admin</h1>
 		<p>Chirpy has been visited %d times!</p>
+		<p>%d chirps from %d users, with %d active sessions.</p>
 	</body>
 	
 	</html>
-	`, cfg.fileserverHits)
+	`, cfg.fileserverHits.Load(), stats.Chirps, stats.Users, stats.ActiveRefreshTokens)
 
 	w.Write([]byte(htmlBody))
 }
 
 func (cfg *apiConfig) metricsReset(w http.ResponseWriter, req *http.Request) {
-	cfg.fileserverHits = 0
+	cfg.fileserverHits.Store(0)
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serverMetrics are the Prometheus metrics exported at /metrics
+type serverMetrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	dbWriteDuration *prometheus.HistogramVec
+}
+
+// routeUnmatched labels requests that matched no route, so unknown paths can't grow the label set
+const routeUnmatched = "unmatched"
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_http_requests_total",
+			Help: "HTTP requests served, by route pattern and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests, by route pattern and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		dbWriteDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_db_write_duration_seconds",
+			Help:    "Time taken by database writes, by operation.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 9),
+		}, []string{"operation"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.dbWriteDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// handler serves the metrics in the Prometheus text exposition format
+func (m *serverMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// middlewareRequireMetricsToken rejects requests without cfg.metricsToken as their bearer token.
+// Everything is rejected when no token is configured.
+func (cfg *apiConfig) middlewareRequireMetricsToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := getBearerToken(r)
+		if !found || cfg.metricsToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) != 1 {
+			requestLogger(r).Warn("Metrics scraped without a valid token")
+			respondWithError(w, http.StatusUnauthorized, "Invalid metrics token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// instrumentStore returns store with its writes timed and exports its record totals
+func (m *serverMetrics) instrumentStore(store database.Store) database.Store {
+	m.registry.MustRegister(newStatsCollector(store))
+	return instrumentedStore{Store: store, writeDuration: m.dbWriteDuration}
+}
+
+// middleware counts and times every request by its chi route pattern.
+// It must be used on a chi router so the pattern is known once the request is served.
+func (m *serverMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := routeUnmatched
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statsCollector reads the store's record totals at scrape time
+type statsCollector struct {
+	store               database.Store
+	chirps              *prometheus.Desc
+	users               *prometheus.Desc
+	activeRefreshTokens *prometheus.Desc
+}
+
+func newStatsCollector(store database.Store) *statsCollector {
+	return &statsCollector{
+		store:               store,
+		chirps:              prometheus.NewDesc("chirpy_chirps", "Chirps in the database.", nil, nil),
+		users:               prometheus.NewDesc("chirpy_users", "Registered users.", nil, nil),
+		activeRefreshTokens: prometheus.NewDesc("chirpy_active_refresh_tokens", "Refresh tokens that are neither revoked nor expired.", nil, nil),
+	}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.chirps
+	ch <- c.users
+	ch <- c.activeRefreshTokens
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.store.Stats(time.Now().UTC())
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.chirps, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.chirps, prometheus.GaugeValue, float64(stats.Chirps))
+	ch <- prometheus.MustNewConstMetric(c.users, prometheus.GaugeValue, float64(stats.Users))
+	ch <- prometheus.MustNewConstMetric(c.activeRefreshTokens, prometheus.GaugeValue, float64(stats.ActiveRefreshTokens))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/totp"
+)
+
+// totpIssuer names chirpy in authenticator apps
+const totpIssuer = "Chirpy"
+
+// purposeLoginMFA is the one-time token purpose of the challenge returned by a password login
+// when the user has two-factor authentication on
+const purposeLoginMFA = "chirpy-login-mfa"
+
+// mfaChallengeTTL is how long a user has to enter their code after the password step
+const mfaChallengeTTL = 5 * time.Minute
+
+// recoveryCodeCount is how many recovery codes a user gets when they turn on two-factor authentication
+const recoveryCodeCount = 10
+
+// after maxTOTPFailures wrong codes in a row, codes are refused until totpLockout has passed
+// since the last one, so six digits can't be guessed
+const (
+	maxTOTPFailures = 5
+	totpLockout     = 15 * time.Minute
+)
+
+var (
+	// errWrongCode means a second factor code did not match
+	errWrongCode = errors.New("wrong two-factor code")
+	// errTOTPLocked means too many wrong codes were entered recently
+	errTOTPLocked = errors.New("too many wrong two-factor codes")
+)
+
+// newTOTPCipher returns the AES-GCM cipher that encrypts TOTP secrets with key
+func newTOTPCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// totpAdditionalData ties an encrypted secret to its user, so a secret copied to another user's row won't decrypt
+func totpAdditionalData(userID int) []byte {
+	return []byte("chirpy-totp:" + strconv.Itoa(userID))
+}
+
+// sealTOTPSecret encrypts secret for storage; the random nonce is prepended to the ciphertext
+func (cfg *apiConfig) sealTOTPSecret(userID int, secret []byte) ([]byte, error) {
+	nonce := make([]byte, cfg.totpCipher.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.totpCipher.Seal(nonce, nonce, secret, totpAdditionalData(userID)), nil
+}
+
+// openTOTPSecret decrypts a secret sealed by sealTOTPSecret
+func (cfg *apiConfig) openTOTPSecret(userID int, sealed []byte) ([]byte, error) {
+	nonceSize := cfg.totpCipher.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("encrypted totp secret is too short")
+	}
+	return cfg.totpCipher.Open(nil, sealed[:nonceSize], sealed[nonceSize:], totpAdditionalData(userID))
+}
+
+// newRecoveryCodes returns fresh recovery codes to show the user, and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := randomHex(8)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeCode undoes the ways people retype codes: spaces, dashes and capitals
+func normalizeCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// isTOTPCode reports whether code looks like an authenticator app code rather than a recovery code
+func isTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSecondFactor accepts either a current code from the user's authenticator app or one of their
+// unused recovery codes, and uses it up. It reports whether a recovery code was used.
+// Every code counts towards a lockout until one is accepted; it returns errWrongCode or errTOTPLocked
+// when the code is refused.
+func (cfg *apiConfig) checkSecondFactor(enrollment database.TOTP, code string, now time.Time) (bool, error) {
+	err := cfg.chirpyDatabase.RecordTOTPAttempt(enrollment.UserID, now, maxTOTPFailures, totpLockout)
+	if errors.Is(err, database.ErrLocked) {
+		return false, errTOTPLocked
+	}
+	if err != nil {
+		return false, err
+	}
+
+	code = normalizeCode(code)
+	usedRecoveryCode := !isTOTPCode(code)
+
+	if usedRecoveryCode {
+		err = cfg.chirpyDatabase.UseRecoveryCode(enrollment.UserID, hashToken(code), now)
+	} else {
+		err = cfg.useTOTPCode(enrollment, code, now)
+	}
+	if errors.Is(err, database.ErrNotExist) || errors.Is(err, database.ErrTokenUsed) {
+		err = errWrongCode
+	}
+	return usedRecoveryCode, err
+}
+
+// useTOTPCode checks code against the enrollment's secret and marks its time step used
+func (cfg *apiConfig) useTOTPCode(enrollment database.TOTP, code string, now time.Time) error {
+	secret, err := cfg.openTOTPSecret(enrollment.UserID, enrollment.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, code, now)
+	if !ok {
+		return errWrongCode
+	}
+	return cfg.chirpyDatabase.UseTOTPStep(enrollment.UserID, step)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+// defaultPageLimit is the page size when a client doesn't send ?limit=,
+// and maxPageLimit the largest page it may ask for
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// cursor kinds, so a cursor from one kind of listing can't be used with another
+const (
+	cursorChirp = "chirp"
+	cursorUser  = "user"
+)
+
+// parseChirpQuery reads the ?sort=, ?limit= and ?cursor= parameters shared by every chirp listing
+func parseChirpQuery(req *http.Request) (database.ChirpQuery, error) {
+	values := req.URL.Query()
+	query := database.ChirpQuery{}
+
+	switch values.Get("sort") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, errors.New("sort must be asc or desc")
+	}
+
+	var err error
+	query.Limit, query.AfterID, err = parsePage(values, cursorChirp)
+	return query, err
+}
+
+// parseUserQuery reads the ?limit= and ?cursor= parameters of a user listing
+func parseUserQuery(req *http.Request) (database.UserQuery, error) {
+	query := database.UserQuery{}
+	var err error
+	query.Limit, query.AfterID, err = parsePage(req.URL.Query(), cursorUser)
+	return query, err
+}
+
+// parsePage reads ?limit= and a ?cursor= of the given kind
+func parsePage(values url.Values, kind string) (limit, afterID int, err error) {
+	limit = defaultPageLimit
+	if value := values.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be a number from 1 to %d", maxPageLimit)
+		}
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		afterID, err = decodeCursor(kind, cursor)
+		if err != nil {
+			return 0, 0, errors.New("invalid cursor")
+		}
+	}
+
+	return limit, afterID, nil
+}
+
+// encodeCursor turns the ID of the last item on a page into an opaque cursor
+func encodeCursor(kind string, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + strconv.Itoa(id)))
+}
+
+func decodeCursor(kind, cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	idString, found := strings.CutPrefix(string(data), kind+":")
+	if !found {
+		return 0, errors.New("malformed cursor")
+	}
+	id, err := strconv.Atoi(idString)
+	if err != nil || id < 1 {
+		return 0, errors.New("malformed cursor")
+	}
+	return id, nil
+}
+
+// peekLimit asks for one item more than the client wants,
+// so paginate can tell whether there is a next page
+func peekLimit(limit int) int {
+	if limit > 0 {
+		return limit + 1
+	}
+	return limit
+}
+
+// peekQuery applies peekLimit to a chirp query
+func peekQuery(query database.ChirpQuery) database.ChirpQuery {
+	query.Limit = peekLimit(query.Limit)
+	return query
+}
+
+// peekUserQuery applies peekLimit to a user query
+func peekUserQuery(query database.UserQuery) database.UserQuery {
+	query.Limit = peekLimit(query.Limit)
+	return query
+}
+
+// paginate trims a page fetched with peekLimit back to limit items and,
+// if more follow, adds a Link header pointing at the next page.
+// cursor returns the cursor that continues after an item.
+func paginate[T any](w http.ResponseWriter, req *http.Request, limit int, items []T, cursor func(T) string) []T {
+	if limit == 0 || len(items) <= limit {
+		return items
+	}
+	items = items[:limit]
+
+	values := req.URL.Query()
+	values.Set("cursor", cursor(items[len(items)-1]))
+	// escaped, since paths such as a tag listing can hold any character
+	next := req.URL.EscapedPath() + "?" + values.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+
+	return items
+}
+
+// chirpCursor continues a chirp listing after chirp
+func chirpCursor(chirp database.Chirp) string {
+	return encodeCursor(cursorChirp, chirp.ID)
+}
+
+// userCursor continues a user listing after user
+func userCursor(user database.User) string {
+	return encodeCursor(cursorUser, user.ID)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, kind := range []string{cursorChirp, cursorUser} {
+		for _, id := range []int{1, 42, 1 << 40} {
+			got, err := decodeCursor(kind, encodeCursor(kind, id))
+			if err != nil || got != id {
+				t.Errorf("decodeCursor(%q, encodeCursor(%q, %d)) = %d, %v", kind, kind, id, got, err)
+			}
+		}
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"other kind", encodeCursor(cursorUser, 5)},
+		{"not base64", "!!!"},
+		{"no kind", "NQ"},
+		{"zero id", encodeCursor(cursorChirp, 0)},
+		{"negative id", encodeCursor(cursorChirp, -3)},
+		{"not a number", "Y2hpcnA6eA"}, // chirp:x
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := decodeCursor(cursorChirp, tt.cursor)
+			if err == nil {
+				t.Errorf("decodeCursor(%q) = %d, want an error", tt.cursor, id)
+			}
+		})
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query       string
+		wantLimit   int
+		wantAfterID int
+		wantErr     bool
+	}{
+		{"", defaultPageLimit, 0, false},
+		{"limit=1", 1, 0, false},
+		{"limit=100", maxPageLimit, 0, false},
+		{"cursor=" + encodeCursor(cursorChirp, 7), defaultPageLimit, 7, false},
+		{"limit=5&cursor=" + encodeCursor(cursorChirp, 7), 5, 7, false},
+		{"limit=0", 0, 0, true},
+		{"limit=101", 0, 0, true},
+		{"limit=-1", 0, 0, true},
+		{"limit=ten", 0, 0, true},
+		{"cursor=" + encodeCursor(cursorUser, 7), 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limit, afterID, err := parsePage(values, cursorChirp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePage(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			}
+			if !tt.wantErr && (limit != tt.wantLimit || afterID != tt.wantAfterID) {
+				t.Errorf("parsePage(%q) = %d, %d, want %d, %d", tt.query, limit, afterID, tt.wantLimit, tt.wantAfterID)
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	chirps := []database.Chirp{{ID: 3}, {ID: 5}, {ID: 8}, {ID: 13}}
+
+	t.Run("last page", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/chirps?limit=4", nil)
+		page := paginate(w, req, 4, chirps, chirpCursor)
+		if len(page) != 4 {
+			t.Errorf("got %d chirps, want 4", len(page))
+		}
+		if link := w.Header().Get("Link"); link != "" {
+			t.Errorf("last page has Link %q", link)
+		}
+	})
+
+	t.Run("more follow", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/tags/caf%C3%A9/chirps?limit=3&sort=desc", nil)
+		page := paginate(w, req, 3, chirps, chirpCursor)
+		if len(page) != 3 || page[2].ID != 8 {
+			t.Fatalf("got %v, want the first three chirps", page)
+		}
+
+		next := nextLink(t, w.Result())
+		if !strings.HasPrefix(next, "/api/tags/caf%C3%A9/chirps?") {
+			t.Errorf("next page %q doesn't keep the escaped path", next)
+		}
+		nextURL, err := url.Parse(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := nextURL.Query()
+		if values.Get("limit") != "3" || values.Get("sort") != "desc" {
+			t.Errorf("next page %q doesn't keep the other parameters", next)
+		}
+		afterID, err := decodeCursor(cursorChirp, values.Get("cursor"))
+		if err != nil || afterID != 8 {
+			t.Errorf("next page cursor continues after %d, %v, want 8", afterID, err)
+		}
+	})
+}
+
+// TestGetChirpsFollowsLinks pages through GET /api/chirps by following Link headers
+func TestGetChirpsFollowsLinks(t *testing.T) {
+	const total = 45
+	for _, driver := range testDrivers {
+		t.Run(driver, func(t *testing.T) {
+			cfg := newTestConfig(t, driver)
+			author, err := cfg.chirpyDatabase.CreateUser("author@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := cfg.chirpyDatabase.CreateUser("other@example.com", "hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []int{}
+			for i := 0; i < total; i++ {
+				authorID := author.ID
+				if i%3 == 0 {
+					authorID = other.ID
+				}
+				chirp, err := cfg.chirpyDatabase.CreateChirp(database.Chirp{Body: "chirp " + strconv.Itoa(i), AuthorID: authorID})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if authorID == author.ID {
+					want = append(want, chirp.ID)
+				}
+			}
+
+			pages, got := followPages(t, cfg, "/api/chirps?author_id="+strconv.Itoa(author.ID))
+			if !slices.Equal(got, want) {
+				t.Errorf("default pages returned %v, want %v", got, want)
+			}
+			if wantPages := (len(want) + defaultPageLimit - 1) / defaultPageLimit; pages != wantPages {
+				t.Errorf("got %d pages, want %d", pages, wantPages)
+			}
+
+			slices.Reverse(want)
+			_, got = followPages(t, cfg, "/api/chirps?sort=desc&limit=7&author_id="+strconv.Itoa(author.ID))
+			if !slices.Equal(got, want) {
+				t.Errorf("descending pages returned %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// followPages gets path and every page linked after it, returning the number of pages and the chirp IDs in order
+func followPages(t *testing.T, cfg *apiConfig, path string) (int, []int) {
+	t.Helper()
+	ids := []int{}
+	pages := 0
+	for path != "" {
+		w := httptest.NewRecorder()
+		cfg.getChirpsHandler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		page := []Chirp{}
+		err := json.NewDecoder(resp.Body).Decode(&page)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		for _, chirp := range page {
+			ids = append(ids, chirp.ID)
+		}
+		pages++
+		path = nextLink(t, resp)
+	}
+	return pages, ids
+}
+
+// nextLink returns the URL of the rel="next" Link header, or "" on the last page
+func nextLink(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	link := resp.Header.Get("Link")
+	if link == "" {
+		return ""
+	}
+	next, found := strings.CutSuffix(link, `>; rel="next"`)
+	next, hasStart := strings.CutPrefix(next, "<")
+	if !found || !hasStart {
+		t.Fatalf("malformed Link header %q", link)
+	}
+	return next
+}
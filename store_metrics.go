@@ -0,0 +1,160 @@
+package main
+
+import (
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instrumentedStore times every write made through the embedded Store.
+// Reads pass straight through.
+type instrumentedStore struct {
+	database.Store
+	writeDuration *prometheus.HistogramVec
+}
+
+// observe records how long the write named operation took since start
+func (s instrumentedStore) observe(operation string, start time.Time) {
+	s.writeDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (s instrumentedStore) CreateChirp(chirp database.Chirp) (database.Chirp, error) {
+	defer s.observe("create_chirp", time.Now())
+	return s.Store.CreateChirp(chirp)
+}
+
+func (s instrumentedStore) DeleteChirp(id int) error {
+	defer s.observe("delete_chirp", time.Now())
+	return s.Store.DeleteChirp(id)
+}
+
+func (s instrumentedStore) SetChirpFlagged(id int, flagged bool) (database.Chirp, error) {
+	defer s.observe("set_chirp_flagged", time.Now())
+	return s.Store.SetChirpFlagged(id, flagged)
+}
+
+func (s instrumentedStore) CreateUser(email, hashedPassword string) (database.User, error) {
+	defer s.observe("create_user", time.Now())
+	return s.Store.CreateUser(email, hashedPassword)
+}
+
+func (s instrumentedStore) UpdateUser(id int, email, hashedPassword string) (database.User, error) {
+	defer s.observe("update_user", time.Now())
+	return s.Store.UpdateUser(id, email, hashedPassword)
+}
+
+func (s instrumentedStore) SetChirpyRed(id int, isChirpyRed bool) (database.User, error) {
+	defer s.observe("set_chirpy_red", time.Now())
+	return s.Store.SetChirpyRed(id, isChirpyRed)
+}
+
+func (s instrumentedStore) SetUserRole(id int, role database.Role) (database.User, error) {
+	defer s.observe("set_user_role", time.Now())
+	return s.Store.SetUserRole(id, role)
+}
+
+func (s instrumentedStore) SetUserVerified(id int, verified bool) (database.User, error) {
+	defer s.observe("set_user_verified", time.Now())
+	return s.Store.SetUserVerified(id, verified)
+}
+
+func (s instrumentedStore) Follow(followerID, followeeID int, at time.Time) error {
+	defer s.observe("follow", time.Now())
+	return s.Store.Follow(followerID, followeeID, at)
+}
+
+func (s instrumentedStore) Unfollow(followerID, followeeID int) error {
+	defer s.observe("unfollow", time.Now())
+	return s.Store.Unfollow(followerID, followeeID)
+}
+
+func (s instrumentedStore) LikeChirp(userID, chirpID int, at time.Time) error {
+	defer s.observe("like_chirp", time.Now())
+	return s.Store.LikeChirp(userID, chirpID, at)
+}
+
+func (s instrumentedStore) UnlikeChirp(userID, chirpID int) error {
+	defer s.observe("unlike_chirp", time.Now())
+	return s.Store.UnlikeChirp(userID, chirpID)
+}
+
+func (s instrumentedStore) CreateRefreshToken(token database.RefreshToken) error {
+	defer s.observe("create_refresh_token", time.Now())
+	return s.Store.CreateRefreshToken(token)
+}
+
+func (s instrumentedStore) RotateRefreshToken(oldHash string, next database.RefreshToken, rotatedAt time.Time) error {
+	defer s.observe("rotate_refresh_token", time.Now())
+	return s.Store.RotateRefreshToken(oldHash, next, rotatedAt)
+}
+
+func (s instrumentedStore) RevokeRefreshToken(tokenHash string, revokedAt time.Time) error {
+	defer s.observe("revoke_refresh_token", time.Now())
+	return s.Store.RevokeRefreshToken(tokenHash, revokedAt)
+}
+
+func (s instrumentedStore) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	defer s.observe("revoke_refresh_token_family", time.Now())
+	return s.Store.RevokeRefreshTokenFamily(familyID, revokedAt)
+}
+
+func (s instrumentedStore) RevokeUserRefreshTokens(userID int, revokedAt time.Time) error {
+	defer s.observe("revoke_user_refresh_tokens", time.Now())
+	return s.Store.RevokeUserRefreshTokens(userID, revokedAt)
+}
+
+func (s instrumentedStore) CreateOneTimeToken(token database.OneTimeToken) error {
+	defer s.observe("create_one_time_token", time.Now())
+	return s.Store.CreateOneTimeToken(token)
+}
+
+func (s instrumentedStore) UseOneTimeToken(id, purpose string, usedAt time.Time) (database.OneTimeToken, error) {
+	defer s.observe("use_one_time_token", time.Now())
+	return s.Store.UseOneTimeToken(id, purpose, usedAt)
+}
+
+func (s instrumentedStore) DiscardOneTimeTokens(userID int, purpose string, at time.Time) error {
+	defer s.observe("discard_one_time_tokens", time.Now())
+	return s.Store.DiscardOneTimeTokens(userID, purpose, at)
+}
+
+func (s instrumentedStore) CreateAuditEvent(event database.AuditEvent) (database.AuditEvent, error) {
+	defer s.observe("create_audit_event", time.Now())
+	return s.Store.CreateAuditEvent(event)
+}
+
+func (s instrumentedStore) StartTOTPEnrollment(userID int, secret []byte) error {
+	defer s.observe("start_totp_enrollment", time.Now())
+	return s.Store.StartTOTPEnrollment(userID, secret)
+}
+
+func (s instrumentedStore) EnableTOTP(userID int, step int64, recoveryCodeHashes []string, enabledAt time.Time) error {
+	defer s.observe("enable_totp", time.Now())
+	return s.Store.EnableTOTP(userID, step, recoveryCodeHashes, enabledAt)
+}
+
+func (s instrumentedStore) UseTOTPStep(userID int, step int64) error {
+	defer s.observe("use_totp_step", time.Now())
+	return s.Store.UseTOTPStep(userID, step)
+}
+
+func (s instrumentedStore) UseRecoveryCode(userID int, hash string, usedAt time.Time) error {
+	defer s.observe("use_recovery_code", time.Now())
+	return s.Store.UseRecoveryCode(userID, hash, usedAt)
+}
+
+func (s instrumentedStore) RecordTOTPAttempt(userID int, at time.Time, maxFailures int, lockout time.Duration) error {
+	defer s.observe("record_totp_attempt", time.Now())
+	return s.Store.RecordTOTPAttempt(userID, at, maxFailures, lockout)
+}
+
+func (s instrumentedStore) DeleteTOTP(userID int) error {
+	defer s.observe("delete_totp", time.Now())
+	return s.Store.DeleteTOTP(userID)
+}
+
+func (s instrumentedStore) Reset() error {
+	defer s.observe("reset", time.Now())
+	return s.Store.Reset()
+}
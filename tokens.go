@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aspiringVegetarian/chirpy_go_web_server/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// makeAccessToken signs a short-lived access JWT for userID
+func (cfg *apiConfig) makeAccessToken(userID int, now time.Time) (string, error) {
+	claims := &jwt.RegisteredClaims{
+		Issuer:    issuerAccess,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(cfg.accessTokenTTL)),
+		Subject:   strconv.Itoa(userID),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(cfg.jwtSecret))
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the form of a token that is safe to keep in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken generates an opaque refresh token for userID in the given family.
+// The token is returned to the client; only the record, which holds its hash, is stored.
+func (cfg *apiConfig) newRefreshToken(userID int, familyID string, now time.Time) (string, database.RefreshToken, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", database.RefreshToken{}, err
+	}
+
+	record := database.RefreshToken{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		FamilyID:  familyID,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(cfg.refreshTokenTTL),
+	}
+	return token, record, nil
+}
+
+// oneTimeClaims are the claims of an emailed one-time token.
+// Email pins the token to the address it was sent to.
+type oneTimeClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// newOneTimeToken signs a single-use token for user, valid for ttl.
+// The purpose doubles as the JWT issuer so a token can't be redeemed for a different purpose.
+// Only the returned record is stored; the signed token goes in the email.
+func (cfg *apiConfig) newOneTimeToken(purpose string, user database.User, ttl time.Duration, now time.Time) (string, database.OneTimeToken, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", database.OneTimeToken{}, err
+	}
+
+	claims := &oneTimeClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Issuer:    purpose,
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.jwtSecret))
+	if err != nil {
+		return "", database.OneTimeToken{}, err
+	}
+
+	record := database.OneTimeToken{
+		ID:        id,
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+	}
+	return signed, record, nil
+}
+
+// redeemOneTimeToken checks a token issued by newOneTimeToken for purpose and marks it used.
+// It returns the user the token was issued to, as they are now.
+func (cfg *apiConfig) redeemOneTimeToken(purpose, tokenString string, now time.Time) (database.User, error) {
+	claims, err := cfg.parseOneTimeToken(purpose, tokenString)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	record, err := cfg.chirpyDatabase.UseOneTimeToken(claims.ID, purpose, now)
+	if err != nil {
+		return database.User{}, err
+	}
+	if strconv.Itoa(record.UserID) != claims.Subject {
+		return database.User{}, errors.New("token subject does not match its record")
+	}
+
+	user, err := cfg.chirpyDatabase.GetUser(record.UserID)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user.Email != claims.Email {
+		return database.User{}, errEmailChanged
+	}
+	return user, nil
+}
+
+// parseOneTimeToken checks the signature, purpose and expiry of a token issued by newOneTimeToken
+// without using it up
+func (cfg *apiConfig) parseOneTimeToken(purpose, tokenString string) (*oneTimeClaims, error) {
+	claims := &oneTimeClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(cfg.jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(purpose))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// errEmailChanged means a one-time token was sent to an address the user no longer has
+var errEmailChanged = errors.New("email address has changed since the token was sent")
+
+// oneTimeTokenProblem explains why redeemOneTimeToken refused a token, to finish a sentence
+// like "This link ...". It returns "" when err is not the token's fault.
+func oneTimeTokenProblem(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "has expired"
+	case errors.Is(err, database.ErrTokenUsed):
+		return "has already been used"
+	case errors.Is(err, errEmailChanged):
+		return "is for an email address you no longer use"
+	case errors.Is(err, database.ErrNotExist),
+		errors.Is(err, jwt.ErrTokenMalformed),
+		errors.Is(err, jwt.ErrTokenSignatureInvalid),
+		errors.Is(err, jwt.ErrTokenUnverifiable),
+		errors.Is(err, jwt.ErrTokenInvalidIssuer),
+		errors.Is(err, jwt.ErrTokenNotValidYet),
+		errors.Is(err, jwt.ErrTokenInvalidClaims):
+		return "is invalid"
+	}
+	return ""
+}